@@ -0,0 +1,126 @@
+package main
+
+import (
+	"context"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+type postedComment struct {
+	userID  int
+	content string
+}
+
+// commentLedger remembers every comment the benchmarker posted so that the
+// review counts and latest comments on GET /?page= can be checked.
+type commentLedger struct {
+	mu       sync.Mutex
+	products map[int]*productComments
+	checkTally
+}
+
+type productComments struct {
+	baseline int // comments right after GET /initialize, see getCommentCount
+	pendingWrites
+	posted []postedComment // oldest first; guarded by mu
+}
+
+var comments = &commentLedger{
+	checkTally: checkTally{label: "Inconsistent comments"},
+	products:   map[int]*productComments{},
+}
+
+func (l *commentLedger) product(productID int) *productComments {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	p, ok := l.products[productID]
+	if !ok {
+		p = &productComments{baseline: getCommentCount(productID)}
+		l.products[productID] = p
+	}
+	return p
+}
+
+// begin must be called before POST /comments/:id is sent
+func (l *commentLedger) begin(productID int) {
+	l.product(productID).begin()
+}
+
+// finish records the outcome of a comment started with begin
+func (l *commentLedger) finish(productID int, comment postedComment, ok bool) {
+	p := l.product(productID)
+	p.finish(ok, func() { p.posted = append(p.posted, comment) })
+}
+
+var reviewCount = regexp.MustCompile(`^([0-9]+)件のレビュー$`)
+
+// verify compares the product on GET /?page= against the ledger; see pendingWrites.verify
+func (l *commentLedger) verify(ctx context.Context, productID int, final bool) bool {
+	p := l.product(productID)
+	path := "/?page=" + strconv.Itoa((10000-productID)/50)
+	var posted []postedComment
+	return p.verify(ctx, &l.checkTally, path, final, func() bool {
+		posted = append([]postedComment{}, p.posted...)
+		return len(posted) > 0
+	}, func(doc *goquery.Document) []validationFailure {
+		endpoint := "GET " + path + " (product " + strconv.Itoa(productID) + ")"
+		return checkProductComments(doc, endpoint, productID, p.baseline, posted)
+	})
+}
+
+// checkProductComments compares a product on the index page with the comments in the ledger
+func checkProductComments(doc *goquery.Document, endpoint string, productID int, baseline int, posted []postedComment) []validationFailure {
+	var failures []validationFailure
+	fail := failuresOf(endpoint, &failures)
+
+	panel := doc.Find(`.panel-heading a[href="/products/` + strconv.Itoa(productID) + `"]`).Closest(".panel")
+	if panel.Length() == 0 {
+		fail("product on index", "/products/"+strconv.Itoa(productID), "not found")
+	} else {
+		expected := baseline + len(posted)
+		actualText := panel.Find(".panel-body h4").Eq(2).Text()
+		m := reviewCount.FindStringSubmatch(actualText)
+		if m == nil {
+			fail("number of reviews", strconv.Itoa(expected)+"件のレビュー", actualText)
+		} else if actual, _ := strconv.Atoi(m[1]); actual < expected {
+			fail("number of reviews (lost comments)", strconv.Itoa(expected)+"件のレビュー", actualText)
+		} else if actual > expected {
+			fail("number of reviews (duplicated comments)", strconv.Itoa(expected)+"件のレビュー", actualText)
+		}
+
+		// The latest comment is listed, cut to 24 characters
+		latest := []rune(posted[len(posted)-1].content)
+		if len(latest) > 24 {
+			latest = latest[:24]
+		}
+		found := false
+		panel.Find(".panel-body li").Each(func(_ int, s *goquery.Selection) {
+			if strings.Contains(s.Text(), string(latest)) {
+				found = true
+			}
+		})
+		if !found {
+			fail("latest comment", string(latest)+"…", strings.TrimSpace(panel.Find(".panel-body li").First().Text()))
+		}
+	}
+	return failures
+}
+
+// verifyAll checks up to maxFinalChecks products the benchmarker commented on.
+// An index page that cannot be fetched fails the check.
+func (l *commentLedger) verifyAll() {
+	l.mu.Lock()
+	ids := make([]int, 0, len(l.products))
+	for id := range l.products {
+		ids = append(ids, id)
+	}
+	l.mu.Unlock()
+
+	for _, id := range finalChecks(ids) {
+		l.verify(context.Background(), id, true)
+	}
+}
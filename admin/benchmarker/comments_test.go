@@ -0,0 +1,68 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+// indexPage renders a page shaped like views/index.ejs with one product,
+// its review count (left out when negative) and its latest comments
+func indexPage(t *testing.T, productID int, reviews int, latest ...string) *goquery.Document {
+	var b strings.Builder
+	b.WriteString(`<html><body><div class="container"><div class="row">`)
+	fmt.Fprintf(&b, `<div class="col-md-4"><div class="panel panel-default"><div class="panel-heading">`+
+		`<a href="/products/%d">商品</a></div><div class="panel-body"><h4>価格</h4><p>100円</p><h4>商品説明</h4><p>…</p>`, productID)
+	if reviews >= 0 {
+		fmt.Fprintf(&b, `<h4>%d件のレビュー</h4>`, reviews)
+	} else {
+		b.WriteString(`<h4></h4>`)
+	}
+	b.WriteString(`<ul>`)
+	for _, c := range latest {
+		if r := []rune(c); len(r) > 25 {
+			c = string(r[:24]) + "…"
+		}
+		fmt.Fprintf(&b, `<li>%s by ユーザー</li>`, c)
+	}
+	b.WriteString(`</ul></div></div></div></div></div></body></html>`)
+	doc, err := goquery.NewDocumentFromReader(strings.NewReader(b.String()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return doc
+}
+
+func TestCheckProductComments(t *testing.T) {
+	first := postedComment{userID: 1, content: strings.Repeat("この商品は二度と買わない。", 5)}
+	last := postedComment{userID: 2, content: strings.Repeat("この商品は友達にも勧めます。", 5)}
+	posted := []postedComment{first, last}
+
+	for _, tt := range []struct {
+		name     string
+		doc      *goquery.Document
+		baseline int
+		checks   []string
+	}{
+		{"matching", indexPage(t, 7, 22, last.content, first.content, "初期データ"), 20, nil},
+		{"other baseline", indexPage(t, 7, 5, last.content, first.content), 3, nil},
+		{"reordered", indexPage(t, 7, 22, first.content, last.content), 20, nil},
+		{"lost", indexPage(t, 7, 21, first.content, "初期データ"), 20,
+			[]string{"number of reviews (lost comments)", "latest comment"}},
+		{"duplicated", indexPage(t, 7, 23, last.content, last.content, first.content), 20,
+			[]string{"number of reviews (duplicated comments)"}},
+		{"missing count", indexPage(t, 7, -1, last.content), 20, []string{"number of reviews"}},
+		{"other product", indexPage(t, 8, 22, last.content), 20, []string{"product on index"}},
+	} {
+		failures := checkProductComments(tt.doc, "GET /?page=199", 7, tt.baseline, posted)
+		var checks []string
+		for _, f := range failures {
+			checks = append(checks, f.Check)
+		}
+		if strings.Join(checks, "; ") != strings.Join(tt.checks, "; ") {
+			t.Errorf("%s: failed %q, want %q", tt.name, checks, tt.checks)
+		}
+	}
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type benchConfig struct {
+	workload int
+	duration time.Duration
+	mix      weightedList
+	output   string
+	seed     int64
+
+	// Ramp-up: add rampStep workers every rampInterval while the target keeps up
+	rampInterval     time.Duration
+	rampStep         int
+	maxWorkload      int
+	rampMaxErrorRate float64
+	rampMaxLatency   time.Duration
+
+	// Open model: virtual users arrive at arrivalRate per second, each runs one
+	// scenario and leaves; 0 keeps the closed model of workload looping workers
+	arrivalRate float64
+	maxUsers    int // arrivals are dropped while this many users are active
+}
+
+// weightedItem is a name with a weight: a scenario of the mix or a target
+type weightedItem struct {
+	name   string
+	weight int
+}
+
+// weightedList is a list of names with weights, written "name=2,name=1"
+type weightedList []weightedItem
+
+// parseMix parses "just=2,stalker=1,bakugai=3"
+func parseMix(s string) (weightedList, error) {
+	var mix weightedList
+	seen := map[string]bool{}
+	for _, part := range strings.Split(s, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		kv := strings.SplitN(part, "=", 2)
+		name := strings.TrimSpace(kv[0])
+		if _, ok := scenarios[name]; !ok {
+			return nil, fmt.Errorf("unknown scenario %q (choose from %s)", name, strings.Join(scenarioNames(), ", "))
+		}
+		if seen[name] {
+			return nil, fmt.Errorf("scenario %q is listed twice", name)
+		}
+		seen[name] = true
+		weight := 1
+		if len(kv) == 2 {
+			w, err := strconv.Atoi(strings.TrimSpace(kv[1]))
+			if err != nil || w < 0 {
+				return nil, fmt.Errorf("invalid weight for %q: %q", name, kv[1])
+			}
+			weight = w
+		}
+		if weight > 0 {
+			mix = append(mix, weightedItem{name: name, weight: weight})
+		}
+	}
+	if len(mix) == 0 {
+		return nil, fmt.Errorf("no scenario with a positive weight")
+	}
+	return mix, nil
+}
+
+// weightedPicker hands out the names of a weightedList following the weights.
+// Smooth weighted round-robin keeps the names interleaved, so a scenario mix
+// with equal weights gives the same just/stalker/bakugai rotation as before.
+type weightedPicker struct {
+	list    weightedList
+	total   int
+	current []int
+	skip    int // names to pass over before the next one handed out
+	stride  int
+}
+
+func (l weightedList) picker() *weightedPicker {
+	total := 0
+	for _, e := range l {
+		total += e.weight
+	}
+	return &weightedPicker{list: l, total: total, current: make([]int, len(l)), stride: 1}
+}
+
+// dealt makes p hand out only every count-th name, starting with the index-th,
+// so that the processes of a distributed run follow the list together.
+func (p *weightedPicker) dealt(index int, count int) *weightedPicker {
+	p.skip, p.stride = index, count
+	return p
+}
+
+func (p *weightedPicker) next() string {
+	for ; p.skip > 0; p.skip-- {
+		p.advance()
+	}
+	p.skip = p.stride - 1
+	return p.advance()
+}
+
+func (p *weightedPicker) advance() string {
+	best := 0
+	for j, e := range p.list {
+		p.current[j] += e.weight
+		if p.current[j] > p.current[best] {
+			best = j
+		}
+	}
+	p.current[best] -= p.total
+	return p.list[best].name
+}
+
+func (l weightedList) String() string {
+	parts := make([]string, 0, len(l))
+	for _, e := range l {
+		parts = append(parts, e.name+"="+strconv.Itoa(e.weight))
+	}
+	return strings.Join(parts, ",")
+}
+
+func scenarioNames() []string {
+	names := make([]string, 0, len(scenarios))
+	for name := range scenarios {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
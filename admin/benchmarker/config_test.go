@@ -0,0 +1,109 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseMix(t *testing.T) {
+	tests := []struct {
+		in   string
+		want weightedList
+	}{
+		{"just=1,stalker=1,bakugai=1", weightedList{{"just", 1}, {"stalker", 1}, {"bakugai", 1}}},
+		{" just = 2 , bakugai=3 ", weightedList{{"just", 2}, {"bakugai", 3}}},
+		{"stalker", weightedList{{"stalker", 1}}},
+		{"just=0,stalker=4,", weightedList{{"stalker", 4}}},
+	}
+	for _, tt := range tests {
+		got, err := parseMix(tt.in)
+		if err != nil {
+			t.Errorf("parseMix(%q): %v", tt.in, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("parseMix(%q) = %v, want %v", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestParseMixErrors(t *testing.T) {
+	for _, in := range []string{
+		"",
+		"just=0",
+		"shopper=1",
+		"just=1,just=2",
+		"just=-1",
+		"just=x",
+	} {
+		if got, err := parseMix(in); err == nil {
+			t.Errorf("parseMix(%q) = %v, want an error", in, got)
+		}
+	}
+}
+
+func pickN(p *weightedPicker, n int) []string {
+	names := make([]string, n)
+	for i := range names {
+		names[i] = p.next()
+	}
+	return names
+}
+
+func TestWeightedPickerNext(t *testing.T) {
+	tests := []struct {
+		list weightedList
+		want []string
+	}{
+		{
+			weightedList{{"just", 1}, {"stalker", 1}, {"bakugai", 1}},
+			[]string{"just", "stalker", "bakugai", "just", "stalker", "bakugai"},
+		},
+		{
+			weightedList{{"just", 2}, {"stalker", 1}},
+			[]string{"just", "stalker", "just", "just", "stalker", "just"},
+		},
+		{
+			weightedList{{"just", 1}},
+			[]string{"just", "just"},
+		},
+	}
+	for _, tt := range tests {
+		if got := pickN(tt.list.picker(), len(tt.want)); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%v: picked %v, want %v", tt.list, got, tt.want)
+		}
+	}
+}
+
+func TestWeightedPickerFollowsWeights(t *testing.T) {
+	list := weightedList{{"just", 5}, {"stalker", 2}, {"bakugai", 3}}
+	counts := map[string]int{}
+	for _, name := range pickN(list.picker(), 100) {
+		counts[name]++
+	}
+	want := map[string]int{"just": 50, "stalker": 20, "bakugai": 30}
+	if !reflect.DeepEqual(counts, want) {
+		t.Errorf("picked %v in 100 picks, want %v", counts, want)
+	}
+}
+
+func TestWeightedPickerDealt(t *testing.T) {
+	list := weightedList{{"just", 2}, {"stalker", 1}, {"bakugai", 3}}
+	const count, rounds = 3, 8
+	whole := pickN(list.picker(), count*rounds)
+
+	// Dealt round by round, the processes together follow the undealt picker
+	dealt := make([]*weightedPicker, count)
+	for i := range dealt {
+		dealt[i] = list.picker().dealt(i, count)
+	}
+	var got []string
+	for r := 0; r < rounds; r++ {
+		for _, p := range dealt {
+			got = append(got, p.next())
+		}
+	}
+	if !reflect.DeepEqual(got, whole) {
+		t.Errorf("dealt picks %v, want %v", got, whole)
+	}
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"math/rand"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+// statusInvalidContent is reported instead of 200 when a sampled response
+// fails the in-load content checks. It costs InvalidContentPenalty, see scoringPolicy.
+const statusInvalidContent = 999
+
+// contentValidator checks the HTML of a sample of responses during the load phase
+type contentValidator struct {
+	checkTally
+	sampleRate  float64
+	maxFailures int
+}
+
+var content = &contentValidator{
+	checkTally:  checkTally{label: "Invalid content during load"},
+	sampleRate:  0.05,
+	maxFailures: 10,
+}
+
+func (cv *contentValidator) sample(r *rand.Rand) bool {
+	return cv.sampleRate > 0 && r.Float64() < cv.sampleRate
+}
+
+// checker returns check when this response is sampled, nil otherwise
+func (cv *contentValidator) checker(r *rand.Rand, check func(*goquery.Document) []validationFailure) func(*goquery.Document) []validationFailure {
+	if !cv.sample(r) {
+		return nil
+	}
+	return check
+}
@@ -0,0 +1,376 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math/rand"
+	"net"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Distributed mode: a coordinator initializes and validates the app, then
+// hands out the load phase to worker processes over HTTP:
+//
+//	POST /register  a worker asks for its job; answered once every worker registered
+//	POST /result    a worker sends its loadReport after the load phase
+//
+// Workers start together workerStartDelay after the last one registered.
+
+const workerStartDelay = 2 * time.Second
+
+// How long the coordinator waits for reports after the load phase
+// (the workers check purchase histories and comments before reporting)
+const workerReportGrace = 2 * time.Minute
+
+// workerJob is the part of the benchmark one worker process runs
+type workerJob struct {
+	Index   int           `json:"index"`
+	Count   int           `json:"count"`
+	StartIn time.Duration `json:"start_in"`
+
+	Duration         time.Duration `json:"duration"`
+	Workload         int           `json:"workload"`
+	Mix              string        `json:"mix"`
+	Seed             int64         `json:"seed"`
+	RampInterval     time.Duration `json:"ramp_interval"`
+	RampStep         int           `json:"ramp_step"`
+	MaxWorkload      int           `json:"max_workload"`
+	RampMaxErrorRate float64       `json:"ramp_max_error_rate"`
+	RampMaxLatency   time.Duration `json:"ramp_max_latency"`
+	ThinkTime        thinkTime     `json:"think_time"`
+	ArrivalRate      float64       `json:"arrival_rate"`
+	MaxUsers         int           `json:"max_users"`
+
+	ContentSampleRate float64        `json:"content_sample_rate"`
+	FailErrorRate     float64        `json:"fail_error_rate"`
+	FailErrorCount    int            `json:"fail_error_count"`
+	FailWindow        time.Duration  `json:"fail_window"`
+	Scoring           *scoringPolicy `json:"scoring"`
+
+	Scenarios map[string]*scenarioDef `json:"scenarios"`
+
+	ExcludedUsers    []int `json:"excluded_users"`
+	ExcludedProducts []int `json:"excluded_products"`
+}
+
+// coordinator hands out jobs and collects the reports. Jobs are handed out
+// only once every worker is waiting, so a worker that drops its connection
+// before that leaves its place to the next one registering.
+type coordinator struct {
+	mu      sync.Mutex
+	workers int
+	jobs    []workerJob // not yet handed out
+	waiting int
+	ready   chan struct{} // closed when every worker has registered
+	start   time.Time     // of the load phase, set when ready is closed
+	reports chan loadReport
+}
+
+func runCoordinator(cfg benchConfig, listen string, workers int) {
+	result := beginBenchmark(cfg)
+
+	c := newCoordinator(workers, splitJobs(cfg, workers))
+	ln, err := net.Listen("tcp", listen)
+	if err != nil {
+		log.Printf("Failed to listen on %s: %v", listen, err)
+		os.Exit(1)
+	}
+	server := &http.Server{Handler: c.handler()}
+	go server.Serve(ln)
+	defer server.Close()
+
+	log.Printf("Waiting for %d workers on %s", workers, ln.Addr())
+	<-c.ready
+	result.StartTime = c.start
+	log.Printf("All workers registered; the load phase starts in %v", workerStartDelay)
+
+	// The coordinator's own statistics are those of the validation, which do not count
+	stats.reset()
+	merged := c.collect(time.After(workerStartDelay + cfg.duration + workerReportGrace))
+	finishBenchmark(cfg, result, merged)
+}
+
+func newCoordinator(workers int, jobs []workerJob) *coordinator {
+	return &coordinator{
+		workers: workers,
+		jobs:    jobs,
+		ready:   make(chan struct{}),
+		reports: make(chan loadReport, workers),
+	}
+}
+
+func (c *coordinator) handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/register", c.handleRegister)
+	mux.HandleFunc("/result", c.handleResult)
+	return mux
+}
+
+// collect merges the reports of the workers, into stats for the statistics,
+// until every worker has reported or timeout fires
+func (c *coordinator) collect(timeout <-chan time.Time) loadReport {
+	merged := loadReport{ScenarioScores: map[string]float64{}}
+	var missing []string
+	for received := 0; received < c.workers; received++ {
+		select {
+		case r := <-c.reports:
+			log.Printf("Worker %d reported: score %d, %d workers", r.Index, r.totalScore(), r.PeakWorkers)
+			merged.merge(r)
+		case <-timeout:
+			missing = append(missing, fmt.Sprintf("%d of %d workers did not report", c.workers-received, c.workers))
+			received = c.workers
+		}
+	}
+	if len(missing) > 0 && merged.AbortReason == "" {
+		merged.AbortReason = strings.Join(missing, "; ")
+	}
+	return merged
+}
+
+// splitJobs divides the workload among the worker processes
+func splitJobs(cfg benchConfig, workers int) []workerJob {
+	var excludedUsers, excludedProducts []int
+	ledger.mu.Lock()
+	for id := range ledger.users {
+		excludedUsers = append(excludedUsers, id)
+	}
+	ledger.mu.Unlock()
+	comments.mu.Lock()
+	for id := range comments.products {
+		excludedProducts = append(excludedProducts, id)
+	}
+	comments.mu.Unlock()
+	sort.Ints(excludedUsers)
+	sort.Ints(excludedProducts)
+
+	seeds := rand.New(rand.NewSource(cfg.seed))
+	jobs := make([]workerJob, workers)
+	for i := range jobs {
+		jobs[i] = workerJob{
+			Index:            i,
+			Count:            workers,
+			Duration:         cfg.duration,
+			Workload:         splitCount(cfg.workload, workers, i),
+			Mix:              cfg.mix.String(),
+			Seed:             seeds.Int63(),
+			RampInterval:     cfg.rampInterval,
+			RampStep:         cfg.rampStep,
+			MaxWorkload:      splitCount(cfg.maxWorkload, workers, i),
+			RampMaxErrorRate: cfg.rampMaxErrorRate,
+			RampMaxLatency:   cfg.rampMaxLatency,
+			ThinkTime:        defaultThinkTime,
+			ArrivalRate:      cfg.arrivalRate / float64(workers),
+			MaxUsers:         splitCount(cfg.maxUsers, workers, i),
+
+			ContentSampleRate: content.sampleRate,
+			FailErrorRate:     failGuard.maxErrorRate,
+			FailErrorCount:    failGuard.maxErrors,
+			FailWindow:        failGuard.window,
+			Scoring:           scoring,
+
+			Scenarios: scenarios,
+
+			ExcludedUsers:    excludedUsers,
+			ExcludedProducts: excludedProducts,
+		}
+	}
+	return jobs
+}
+
+// splitCount returns the share of n that worker i of count gets
+func splitCount(n int, count int, i int) int {
+	part := n / count
+	if i < n%count {
+		part++
+	}
+	return part
+}
+
+func (c *coordinator) handleRegister(w http.ResponseWriter, r *http.Request) {
+	c.mu.Lock()
+	if len(c.jobs) == 0 {
+		c.mu.Unlock()
+		http.Error(w, "every worker has already registered", http.StatusConflict)
+		return
+	}
+	select {
+	case <-c.ready:
+		// A worker that reconnected after the others got their jobs
+	default:
+		c.waiting++
+		log.Printf("Worker registered from %s (%d of %d)", r.RemoteAddr, c.waiting, c.workers)
+		if c.waiting == c.workers {
+			c.start = time.Now().Add(workerStartDelay)
+			close(c.ready)
+		}
+	}
+	c.mu.Unlock()
+
+	select {
+	case <-c.ready:
+	case <-r.Context().Done():
+		c.mu.Lock()
+		select {
+		case <-c.ready:
+		default:
+			c.waiting--
+		}
+		c.mu.Unlock()
+		return
+	}
+
+	c.mu.Lock()
+	if len(c.jobs) == 0 {
+		c.mu.Unlock()
+		http.Error(w, "every worker has already registered", http.StatusConflict)
+		return
+	}
+	job := c.jobs[0]
+	c.jobs = c.jobs[1:]
+	c.mu.Unlock()
+
+	job.StartIn = time.Until(c.start)
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(job); err != nil {
+		// The worker is gone; give the job to the next one registering
+		c.mu.Lock()
+		c.jobs = append(c.jobs, job)
+		c.mu.Unlock()
+		return
+	}
+	log.Printf("Worker %d assigned to %s", job.Index, r.RemoteAddr)
+}
+
+func (c *coordinator) handleResult(w http.ResponseWriter, r *http.Request) {
+	var report loadReport
+	if err := json.NewDecoder(r.Body).Decode(&report); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	c.reports <- report
+}
+
+// merge adds the report of another worker process
+func (r *loadReport) merge(o loadReport) {
+	for name, score := range o.ScenarioScores {
+		r.ScenarioScores[name] += score
+	}
+	r.PeakWorkers += o.PeakWorkers
+	r.Arrivals += o.Arrivals
+	r.Dropped += o.Dropped
+	if o.AbortReason != "" && r.AbortReason == "" {
+		r.AbortReason = fmt.Sprintf("worker %d: %s", o.Index, o.AbortReason)
+	}
+	r.ContentChecks = mergeChecks(r.ContentChecks, o.ContentChecks)
+	r.PurchaseChecks = mergeChecks(r.PurchaseChecks, o.PurchaseChecks)
+	r.CommentChecks = mergeChecks(r.CommentChecks, o.CommentChecks)
+	stats.merge(o.Stats)
+}
+
+func mergeChecks(a, b checkResult) checkResult {
+	failures := append(append([]validationFailure{}, a.Failures...), b.Failures...)
+	if len(failures) > maxReportedFailures {
+		failures = failures[:maxReportedFailures]
+	}
+	return checkResult{Checked: a.Checked + b.Checked, Failed: a.Failed + b.Failed, Failures: failures}
+}
+
+// runWorker registers with the coordinator, runs the load phase it is
+// given and sends back the report
+func runWorker(coordinatorURL string) {
+	coordinatorURL = strings.TrimSuffix(coordinatorURL, "/")
+	loadUsers()
+	getProductPrice(0)
+	getTotalPay(0)
+	getCommentCount(0)
+
+	log.Printf("Registering with %s", coordinatorURL)
+	resp, err := http.Post(coordinatorURL+"/register", "application/json", nil)
+	if err != nil {
+		log.Printf("Failed to register: %v", err)
+		os.Exit(1)
+	}
+	var job workerJob
+	err = json.NewDecoder(resp.Body).Decode(&job)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || err != nil {
+		log.Printf("Failed to register: status %d, %v", resp.StatusCode, err)
+		os.Exit(1)
+	}
+	start := time.Now().Add(job.StartIn)
+
+	if job.Scenarios != nil {
+		scenarios = job.Scenarios
+	}
+	mix, err := parseMix(job.Mix)
+	if err != nil {
+		log.Printf("Invalid job: %v", err)
+		os.Exit(1)
+	}
+	cfg := benchConfig{
+		workload:         job.Workload,
+		duration:         job.Duration,
+		mix:              mix,
+		seed:             job.Seed,
+		rampInterval:     job.RampInterval,
+		rampStep:         job.RampStep,
+		maxWorkload:      job.MaxWorkload,
+		rampMaxErrorRate: job.RampMaxErrorRate,
+		rampMaxLatency:   job.RampMaxLatency,
+		arrivalRate:      job.ArrivalRate,
+		maxUsers:         job.MaxUsers,
+	}
+	defaultThinkTime = job.ThinkTime
+	content.sampleRate = job.ContentSampleRate
+	failGuard.maxErrorRate = job.FailErrorRate
+	failGuard.maxErrors = job.FailErrorCount
+	failGuard.window = job.FailWindow
+	if job.Scoring != nil {
+		scoring = job.Scoring
+	}
+	share = &workShare{
+		index:            job.Index,
+		count:            job.Count,
+		excludedUsers:    map[int]bool{},
+		excludedProducts: map[int]bool{},
+	}
+	for _, id := range job.ExcludedUsers {
+		share.excludedUsers[id] = true
+	}
+	for _, id := range job.ExcludedProducts {
+		share.excludedProducts[id] = true
+	}
+	log.Printf("Worker %d of %d: workload %d, mix %s, seed %d", job.Index, job.Count, cfg.workload, cfg.mix, cfg.seed)
+
+	time.Sleep(time.Until(start))
+	ctx, cancel := context.WithDeadline(context.Background(), start.Add(cfg.duration))
+	defer cancel()
+	report := runLoadPhase(ctx, cancel, cfg)
+	report.Index = job.Index
+	log.Printf("Load phase finished: score %d", report.totalScore())
+
+	b, err := json.Marshal(report)
+	if err != nil {
+		log.Printf("Failed to encode the report: %v", err)
+		os.Exit(1)
+	}
+	resp, err = http.Post(coordinatorURL+"/result", "application/json", bytes.NewReader(b))
+	if err != nil {
+		log.Printf("Failed to send the report: %v", err)
+		os.Exit(1)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		log.Printf("Failed to send the report: status %d", resp.StatusCode)
+		os.Exit(1)
+	}
+	log.Print("Report sent")
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"testing"
+	"time"
+)
+
+// register asks the coordinator for a job like runWorker does
+func register(ctx context.Context, url string) (workerJob, time.Time, error) {
+	var job workerJob
+	req, _ := http.NewRequest("POST", url+"/register", nil)
+	resp, err := http.DefaultClient.Do(req.WithContext(ctx))
+	if err != nil {
+		return job, time.Time{}, err
+	}
+	defer resp.Body.Close()
+	err = json.NewDecoder(resp.Body).Decode(&job)
+	return job, time.Now().Add(job.StartIn), err
+}
+
+func (c *coordinator) waitingWorkers() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.waiting
+}
+
+// waitFor polls cond until it holds or a second has passed
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	for deadline := time.Now().Add(time.Second); !cond(); time.Sleep(5 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+	}
+}
+
+// workerStats returns the statistics of a worker that sent n requests to GET /
+func workerStats(n int, status int) statsSnapshot {
+	s := &statsRecorder{endpoints: map[string]*endpointStats{}, targets: map[string]*endpointStats{}, errorStatuses: map[int]int{}}
+	for i := 0; i < n; i++ {
+		s.record("http://127.0.0.1", "GET /", status, 10*time.Millisecond)
+	}
+	return s.snapshot()
+}
+
+func TestCoordinator(t *testing.T) {
+	const workers = 3
+	c := newCoordinator(workers, splitJobs(benchConfig{workload: 7, duration: time.Second, seed: 1}, workers))
+	server := httptest.NewServer(c.handler())
+	defer server.Close()
+
+	// A worker that gives up before the others register leaves its place
+	ctx, cancel := context.WithCancel(context.Background())
+	dropped := make(chan error, 1)
+	go func() {
+		_, _, err := register(ctx, server.URL)
+		dropped <- err
+	}()
+	waitFor(t, "the first registration", func() bool { return c.waitingWorkers() == 1 })
+	cancel()
+	if err := <-dropped; err == nil {
+		t.Fatal("canceled registration: got a job")
+	}
+	waitFor(t, "the dropped registration", func() bool { return c.waitingWorkers() == 0 })
+
+	type registration struct {
+		job   workerJob
+		start time.Time
+		err   error
+	}
+	registered := make(chan registration, workers)
+	for i := 0; i < workers; i++ {
+		go func() {
+			job, start, err := register(context.Background(), server.URL)
+			registered <- registration{job, start, err}
+		}()
+	}
+	var indexes, workloads []int
+	var starts []time.Time
+	for i := 0; i < workers; i++ {
+		r := <-registered
+		if r.err != nil {
+			t.Fatalf("register: %v", r.err)
+		}
+		indexes = append(indexes, r.job.Index)
+		workloads = append(workloads, r.job.Workload)
+		starts = append(starts, r.start)
+		if r.job.Count != workers || r.job.StartIn <= 0 || r.job.StartIn > workerStartDelay {
+			t.Errorf("job %d: count %d, start in %v", r.job.Index, r.job.Count, r.job.StartIn)
+		}
+	}
+	sort.Ints(indexes)
+	sort.Ints(workloads)
+	if indexes[0] != 0 || indexes[1] != 1 || indexes[2] != 2 {
+		t.Errorf("job indexes %v, want 0, 1 and 2", indexes)
+	}
+	if workloads[0] != 2 || workloads[1] != 2 || workloads[2] != 3 {
+		t.Errorf("workloads %v, want 7 split as 2, 2 and 3", workloads)
+	}
+	// Every worker starts at the coordinator's start time, whenever it got its job
+	for _, start := range starts {
+		if d := start.Sub(c.start); d < -100*time.Millisecond || d > 100*time.Millisecond {
+			t.Errorf("worker starts %v away from the coordinator", d)
+		}
+	}
+	if _, _, err := register(context.Background(), server.URL); err == nil {
+		t.Error("a fourth worker got a job")
+	}
+
+	// Two workers report, the third never does
+	for _, report := range []loadReport{
+		{Index: 0, ScenarioScores: map[string]float64{"just": 100, "bakugai": 50.4}, PeakWorkers: 2, Stats: workerStats(3, 200),
+			PurchaseChecks: checkResult{Checked: 4}},
+		{Index: 2, ScenarioScores: map[string]float64{"just": 20}, PeakWorkers: 3, Stats: workerStats(2, 500),
+			PurchaseChecks: checkResult{Checked: 1, Failed: 1, Failures: []validationFailure{{Check: "total purchase amount"}}}},
+	} {
+		b, _ := json.Marshal(report)
+		resp, err := http.Post(server.URL+"/result", "application/json", bytes.NewReader(b))
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("POST /result: status %d", resp.StatusCode)
+		}
+	}
+
+	stats.reset()
+	merged := c.collect(time.After(100 * time.Millisecond))
+	if got := merged.ScenarioScores; got["just"] != 120 || got["bakugai"] != 50.4 || merged.totalScore() != 170 {
+		t.Errorf("merged scores %v (total %d), want just=120, bakugai=50.4", got, merged.totalScore())
+	}
+	if merged.PeakWorkers != 5 {
+		t.Errorf("merged peak workers %d, want 5", merged.PeakWorkers)
+	}
+	if p := merged.PurchaseChecks; p.Checked != 5 || p.Failed != 1 || len(p.Failures) != 1 {
+		t.Errorf("merged purchase checks %+v, want 5 checked and 1 failed", p)
+	}
+	if merged.AbortReason != "1 of 3 workers did not report" {
+		t.Errorf("abort reason %q, want the missing worker", merged.AbortReason)
+	}
+	snap := stats.snapshot()
+	if e := snap.Endpoints["GET /"]; e.Requests != 5 || e.Errors != 2 || snap.ErrorStatuses[500] != 2 {
+		t.Errorf("merged stats of GET /: %d requests, %d errors, %v; want 5 requests, 2 errors", e.Requests, e.Errors, snap.ErrorStatuses)
+	}
+}
+
+func TestLoadReportMergeAbort(t *testing.T) {
+	merged := loadReport{ScenarioScores: map[string]float64{}}
+	merged.merge(loadReport{Index: 0, ScenarioScores: map[string]float64{"just": 1}})
+	merged.merge(loadReport{Index: 1, AbortReason: "error rate 60.00%"})
+	merged.merge(loadReport{Index: 2, AbortReason: "10 errors"})
+	if merged.AbortReason != "worker 1: error rate 60.00%" {
+		t.Errorf("abort reason %q, want the first worker that aborted", merged.AbortReason)
+	}
+}
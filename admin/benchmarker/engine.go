@@ -0,0 +1,343 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"sort"
+	"strings"
+	"time"
+)
+
+// scenarioDef is a scenario described as a list of steps; see scenario.go
+// for the built-in ones and scenarios.example.json for a scenario file.
+type scenarioDef struct {
+	Description string         `json:"description"`
+	Steps       []scenarioStep `json:"steps"`
+}
+
+type scenarioStep struct {
+	Action string                `json:"action"`
+	Params map[string]*paramSpec `json:"params,omitempty"`
+	Repeat int                   `json:"repeat,omitempty"` // times to run the step (default: 1)
+	OneIn  int                   `json:"one_in,omitempty"` // run the step with a chance of 1 in OneIn (default: always)
+	Think  *thinkTime            `json:"think,omitempty"`  // pause after each run of the step (default: --think-time)
+}
+
+// Actions a step can take, with the parameters each one accepts. The score
+// is flushed at every checkpoint and at the end of the scenario.
+var scenarioActions = map[string][]actionParam{
+	"login":            {{"user", 1, 5000, true}},
+	"logout":           nil,
+	"index":            {{"page", 0, 199, false}},
+	"image":            {{"id", 0, 4, false}},
+	"product":          {{"id", 1, 10000, true}},
+	"user":             {{"id", 1, 5000, true}},
+	"buy":              {{"product", 1, 10000, true}}, // as the logged-in user
+	"comment":          {{"product", 1, 10000, true}}, // as the logged-in user
+	"verify_purchases": nil,                           // check the purchase history of the logged-in user
+	"verify_comments":  nil,                           // check the last product commented on shows the comments
+	"checkpoint":       nil,
+}
+
+// actionParam is a step parameter and the values it may take: those of the
+// initial data, so that the pages requested can be checked
+type actionParam struct {
+	name     string
+	min, max int
+	random   bool // 0 picks a random user or product
+}
+
+func (p actionParam) within(spec *paramSpec) bool {
+	min := p.min
+	if p.random {
+		min = 0
+	}
+	return spec.within(min, p.max)
+}
+
+func (p actionParam) String() string {
+	if p.random {
+		return fmt.Sprintf("%s must be from %d to %d (0 = random)", p.name, p.min, p.max)
+	}
+	return fmt.Sprintf("%s must be from %d to %d", p.name, p.min, p.max)
+}
+
+// paramSpec is the value of a step parameter: a number, or one of
+//
+//	{"rand": [FROM, TO]}    a random value from FROM to TO
+//	{"cycle": [FROM, TO]}   FROM, FROM+1, ... TO, FROM, ... over the repeats of the step
+//	{"choice": [A, B, ...]} one of the values at random
+type paramSpec struct {
+	fixed  int
+	Rand   []int `json:"rand,omitempty"`
+	Cycle  []int `json:"cycle,omitempty"`
+	Choice []int `json:"choice,omitempty"`
+}
+
+func (p *paramSpec) UnmarshalJSON(b []byte) error {
+	if err := json.Unmarshal(b, &p.fixed); err == nil {
+		return nil
+	}
+	type spec paramSpec
+	d := json.NewDecoder(bytes.NewReader(b))
+	d.DisallowUnknownFields()
+	if err := d.Decode((*spec)(p)); err != nil {
+		return fmt.Errorf("parameter %s: want a number, {\"rand\": [FROM, TO]}, {\"cycle\": [FROM, TO]} or {\"choice\": [...]}", b)
+	}
+	set := 0
+	for _, r := range [][]int{p.Rand, p.Cycle} {
+		if r == nil {
+			continue
+		}
+		set++
+		if len(r) != 2 || r[0] > r[1] {
+			return fmt.Errorf("parameter %s: a range needs FROM <= TO", b)
+		}
+	}
+	if p.Choice != nil {
+		set++
+		if len(p.Choice) == 0 {
+			return fmt.Errorf("parameter %s: nothing to choose from", b)
+		}
+	}
+	if set != 1 {
+		return fmt.Errorf("parameter %s: give exactly one of rand, cycle and choice", b)
+	}
+	return nil
+}
+
+func (p *paramSpec) MarshalJSON() ([]byte, error) {
+	if p.Rand == nil && p.Cycle == nil && p.Choice == nil {
+		return json.Marshal(p.fixed)
+	}
+	type spec paramSpec
+	return json.Marshal((*spec)(p))
+}
+
+// within reports whether every value p can take is from min to max
+func (p *paramSpec) within(min, max int) bool {
+	values := p.Choice
+	switch {
+	case p.Rand != nil:
+		values = p.Rand
+	case p.Cycle != nil:
+		values = p.Cycle
+	case p.Choice == nil:
+		values = []int{p.fixed}
+	}
+	for _, v := range values {
+		if v < min || v > max {
+			return false
+		}
+	}
+	return true
+}
+
+// value returns the parameter for the i-th repeat of a step
+func (p *paramSpec) value(r *rand.Rand, i int) int {
+	switch {
+	case p == nil:
+		return 0
+	case p.Rand != nil:
+		return getRand(r, p.Rand[0], p.Rand[1])
+	case p.Cycle != nil:
+		return p.Cycle[0] + i%(p.Cycle[1]-p.Cycle[0]+1)
+	case p.Choice != nil:
+		return p.Choice[r.Intn(len(p.Choice))]
+	default:
+		return p.fixed
+	}
+}
+
+// Scenarios selectable from --mix: the built-in ones, plus those of --scenarios
+var scenarios = builtinScenarios()
+
+func builtinScenarios() map[string]*scenarioDef {
+	s, err := parseScenarios([]byte(defaultScenarios))
+	if err != nil {
+		panic("built-in scenarios: " + err.Error())
+	}
+	return s
+}
+
+// loadScenarioFile reads a scenario file. Its scenarios are added to the
+// built-in ones, replacing those with the same name.
+func loadScenarioFile(path string) (map[string]*scenarioDef, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	loaded, err := parseScenarios(b)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	s := builtinScenarios()
+	for name, def := range loaded {
+		s[name] = def
+	}
+	return s, nil
+}
+
+func parseScenarios(b []byte) (map[string]*scenarioDef, error) {
+	var s map[string]*scenarioDef
+	d := json.NewDecoder(bytes.NewReader(b))
+	d.DisallowUnknownFields()
+	if err := d.Decode(&s); err != nil {
+		return nil, err
+	}
+	for name, def := range s {
+		if name == "" || strings.ContainsAny(name, ",= ") {
+			return nil, fmt.Errorf("invalid scenario name %q", name)
+		}
+		if def == nil || len(def.Steps) == 0 {
+			return nil, fmt.Errorf("scenario %q has no steps", name)
+		}
+		if err := def.check(); err != nil {
+			return nil, fmt.Errorf("scenario %q: %v", name, err)
+		}
+	}
+	return s, nil
+}
+
+// check rejects steps the engine could not run
+func (def *scenarioDef) check() error {
+	loggedIn := false
+	for i, step := range def.Steps {
+		params, ok := scenarioActions[step.Action]
+		if !ok {
+			return fmt.Errorf("step %d: unknown action %q (choose from %s)", i+1, step.Action, strings.Join(actionNames(), ", "))
+		}
+		for p, spec := range step.Params {
+			param, ok := findParam(params, p)
+			if !ok {
+				return fmt.Errorf("step %d: %s takes no parameter %q", i+1, step.Action, p)
+			}
+			if !param.within(spec) {
+				return fmt.Errorf("step %d: %v", i+1, param)
+			}
+		}
+		if step.Repeat < 0 || step.OneIn < 0 {
+			return fmt.Errorf("step %d: repeat and one_in must not be negative", i+1)
+		}
+		switch step.Action {
+		case "login":
+			// A login that may be skipped does not log in the steps after it
+			if step.OneIn <= 1 {
+				loggedIn = true
+			}
+		case "logout":
+			loggedIn = false
+		case "buy", "comment", "verify_purchases":
+			if !loggedIn {
+				return fmt.Errorf("step %d: %s needs a login step before it", i+1, step.Action)
+			}
+		}
+	}
+	return nil
+}
+
+// runScenario runs a scenario once, with a new session.
+// Return value: Whether this goroutine should terminate.
+func runScenario(ctx context.Context, a *agent, name string, def *scenarioDef) bool {
+	score := 0.0
+	a.reset() //New session
+
+	var userID, productID int // the logged-in user and the last product commented on
+	for _, step := range def.Steps {
+		if step.OneIn > 1 && getRand(a.rand, 1, step.OneIn) != 1 {
+			continue
+		}
+		think := defaultThinkTime
+		if step.Think != nil {
+			think = *step.Think
+		}
+		repeat := step.Repeat
+		if repeat == 0 {
+			repeat = 1
+		}
+		for i := 0; i < repeat; i++ {
+			var resp response
+			switch step.Action {
+			case "login":
+				var email, password string
+				userID, email, password = getUserInfo(a.rand, step.param("user", a.rand, i))
+				resp = postLogin(ctx, a, email, password)
+			case "logout":
+				resp = getLogout(ctx, a)
+			case "index":
+				resp = getIndex(ctx, a, step.param("page", a.rand, i))
+			case "image":
+				resp = getImage(ctx, a, step.param("id", a.rand, i))
+			case "product":
+				resp = getProduct(ctx, a, step.param("id", a.rand, i))
+			case "user":
+				resp = getUserPage(ctx, a, step.param("id", a.rand, i))
+			case "buy":
+				resp = buyProduct(ctx, a, userID, step.param("product", a.rand, i))
+			case "comment":
+				productID = step.param("product", a.rand, i)
+				if productID == 0 {
+					productID = share.product(a.rand)
+				}
+				resp = sendComment(ctx, a, userID, productID)
+			case "verify_purchases":
+				ledger.verify(ctx, userID, false)
+				continue
+			case "verify_comments":
+				if productID > 0 {
+					comments.verify(ctx, productID, false)
+				}
+				continue
+			case "checkpoint":
+				if updateScore(ctx, name, score) {
+					return true
+				}
+				score = 0
+				continue
+			}
+			score = calcScore(score, resp)
+			if pause := think.sample(a.rand); pause > 0 && sleepContext(ctx, pause) {
+				return updateScore(ctx, name, score)
+			}
+		}
+	}
+	return updateScore(ctx, name, score)
+}
+
+func (step *scenarioStep) param(name string, r *rand.Rand, i int) int {
+	return step.Params[name].value(r, i)
+}
+
+// sleepContext waits for d and reports whether ctx was done first
+func sleepContext(ctx context.Context, d time.Duration) bool {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+		return true
+	case <-t.C:
+		return false
+	}
+}
+
+func actionNames() []string {
+	names := make([]string, 0, len(scenarioActions))
+	for name := range scenarioActions {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func findParam(params []actionParam, name string) (actionParam, bool) {
+	for _, p := range params {
+		if p.name == name {
+			return p, true
+		}
+	}
+	return actionParam{}, false
+}
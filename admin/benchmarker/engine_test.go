@@ -0,0 +1,151 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"math/rand"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParamSpecUnmarshal(t *testing.T) {
+	for _, tt := range []struct {
+		in   string
+		want paramSpec
+	}{
+		{`42`, paramSpec{fixed: 42}},
+		{`{"rand": [1, 10]}`, paramSpec{Rand: []int{1, 10}}},
+		{`{"cycle": [3, 3]}`, paramSpec{Cycle: []int{3, 3}}},
+		{`{"choice": [7, 8, 9]}`, paramSpec{Choice: []int{7, 8, 9}}},
+	} {
+		var got paramSpec
+		if err := json.Unmarshal([]byte(tt.in), &got); err != nil {
+			t.Errorf("%s: %v", tt.in, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: got %+v, want %+v", tt.in, got, tt.want)
+		}
+		b, err := json.Marshal(&got)
+		if err != nil || strings.Replace(tt.in, " ", "", -1) != string(b) {
+			t.Errorf("%s: marshaled back to %s (%v)", tt.in, b, err)
+		}
+	}
+}
+
+func TestParamSpecUnmarshalErrors(t *testing.T) {
+	for _, in := range []string{
+		`"5"`,
+		`{}`,
+		`{"rand": [1]}`,
+		`{"rand": [10, 1]}`,
+		`{"cycle": [1, 2, 3]}`,
+		`{"choice": []}`,
+		`{"rand": [1, 2], "choice": [1]}`,
+		`{"random": [1, 2]}`,
+	} {
+		var p paramSpec
+		if err := json.Unmarshal([]byte(in), &p); err == nil {
+			t.Errorf("%s: got %+v, want an error", in, p)
+		}
+	}
+}
+
+func TestParamSpecValue(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	var none *paramSpec
+	if v := none.value(r, 0); v != 0 {
+		t.Errorf("missing parameter = %d, want 0", v)
+	}
+	fixed := &paramSpec{fixed: 5}
+	cycle := &paramSpec{Cycle: []int{3, 5}}
+	for i, want := range []int{3, 4, 5, 3, 4} {
+		if v := fixed.value(r, i); v != 5 {
+			t.Errorf("fixed: repeat %d = %d, want 5", i, v)
+		}
+		if v := cycle.value(r, i); v != want {
+			t.Errorf("cycle: repeat %d = %d, want %d", i, v, want)
+		}
+	}
+	random := &paramSpec{Rand: []int{10, 12}}
+	choice := &paramSpec{Choice: []int{2, 4}}
+	for i := 0; i < 100; i++ {
+		if v := random.value(r, i); v < 10 || v > 12 {
+			t.Errorf("rand: %d is out of [10, 12]", v)
+		}
+		if v := choice.value(r, i); v != 2 && v != 4 {
+			t.Errorf("choice: %d is not one of the choices", v)
+		}
+	}
+}
+
+func TestScenarioCheck(t *testing.T) {
+	for _, tt := range []struct {
+		steps string
+		err   string // part of the error, empty if the scenario is valid
+	}{
+		{`[{"action": "login"}, {"action": "buy"}, {"action": "verify_purchases"}, {"action": "logout"}]`, ""},
+		{`[{"action": "login", "params": {"user": 5000}}, {"action": "comment", "params": {"product": {"rand": [1, 10000]}}}]`, ""},
+		{`[{"action": "dance"}]`, "unknown action"},
+		{`[{"action": "index", "params": {"id": 1}}]`, "takes no parameter"},
+		{`[{"action": "buy"}]`, "needs a login"},
+		{`[{"action": "login"}, {"action": "logout"}, {"action": "comment"}]`, "needs a login"},
+		{`[{"action": "login", "one_in": 2}, {"action": "buy"}]`, "needs a login"},
+		{`[{"action": "login", "params": {"user": 5001}}]`, "user must be from 1 to 5000"},
+		{`[{"action": "login", "params": {"user": {"choice": [1, -1]}}}]`, "user must be from 1 to 5000"},
+		{`[{"action": "login"}, {"action": "buy", "params": {"product": {"cycle": [9990, 10010]}}}]`, "product must be from 1 to 10000"},
+		{`[{"action": "index", "repeat": -1}]`, "must not be negative"},
+		{`[{"action": "index", "params": {"page": {"rand": [0, 199]}}}, {"action": "user", "params": {"id": {"choice": [5000, 0]}}}]`, ""},
+		{`[{"action": "index", "params": {"page": 200}}]`, "page must be from 0 to 199"},
+		{`[{"action": "index", "params": {"page": -1}}]`, "page must be from 0 to 199"},
+		{`[{"action": "image", "params": {"id": {"cycle": [0, 5]}}}]`, "id must be from 0 to 4"},
+		{`[{"action": "product", "params": {"id": 10001}}]`, "id must be from 1 to 10000"},
+		{`[{"action": "user", "params": {"id": {"rand": [1, 9999]}}}]`, "id must be from 1 to 5000"},
+	} {
+		var def scenarioDef
+		if err := json.Unmarshal([]byte(`{"steps": `+tt.steps+`}`), &def); err != nil {
+			t.Fatalf("%s: %v", tt.steps, err)
+		}
+		err := def.check()
+		switch {
+		case tt.err == "" && err != nil:
+			t.Errorf("%s: %v", tt.steps, err)
+		case tt.err != "" && (err == nil || !strings.Contains(err.Error(), tt.err)):
+			t.Errorf("%s: got error %v, want %q", tt.steps, err, tt.err)
+		}
+	}
+}
+
+func TestParseScenarios(t *testing.T) {
+	s, err := parseScenarios([]byte(defaultScenarios))
+	if err != nil {
+		t.Fatalf("built-in scenarios: %v", err)
+	}
+	for _, name := range []string{"just", "stalker", "bakugai"} {
+		if s[name] == nil {
+			t.Errorf("built-in scenario %q is missing", name)
+		}
+	}
+
+	b, err := ioutil.ReadFile("scenarios.example.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := parseScenarios(b); err != nil {
+		t.Errorf("scenarios.example.json: %v", err)
+	}
+
+	for _, in := range []string{
+		`{"a b": {"steps": [{"action": "index"}]}}`,
+		`{"a=1": {"steps": [{"action": "index"}]}}`,
+		`{"empty": {"steps": []}}`,
+		`{"typo": {"step": [{"action": "index"}]}}`,
+		`{"typo": {"steps": [{"action": "index", "repeats": 2}]}}`,
+		`{"buyer": {"steps": [{"action": "buy"}]}}`,
+	} {
+		if _, err := parseScenarios([]byte(in)); err == nil {
+			t.Errorf("%s: want an error", in)
+		}
+	}
+}
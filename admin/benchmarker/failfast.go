@@ -0,0 +1,122 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+)
+
+// The error rate rule needs at least this many requests in the window,
+// so that a few errors right after the start do not abort the run
+const failMinRequests = 50
+
+// failureGuard aborts the load phase when the target keeps failing: more than
+// maxErrorRate of the requests, or more than maxErrors errors, within the last window.
+type failureGuard struct {
+	mu           sync.Mutex
+	window       time.Duration
+	maxErrorRate float64 // 0 disables the rule
+	maxErrors    int     // 0 disables the rule
+	buckets      []errorBucket
+	reason       string // why the run was aborted, empty if it was not
+}
+
+// errorBucket counts the requests finished within one second
+type errorBucket struct {
+	second   int64
+	requests int
+	errors   int
+}
+
+var failGuard = new(failureGuard)
+
+func (g *failureGuard) enabled() bool {
+	return g.window > 0 && (g.maxErrorRate > 0 || g.maxErrors > 0)
+}
+
+// reset clears the window; requests made before it (e.g. by the validator) are not counted
+func (g *failureGuard) reset() {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	n := int((g.window + time.Second - 1) / time.Second)
+	if n < 1 {
+		n = 1
+	}
+	g.buckets = make([]errorBucket, n)
+	g.reason = ""
+}
+
+func (g *failureGuard) record(status int) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if len(g.buckets) == 0 {
+		return
+	}
+	now := time.Now().Unix()
+	b := &g.buckets[now%int64(len(g.buckets))]
+	if b.second != now {
+		*b = errorBucket{second: now}
+	}
+	b.requests++
+	if status >= 400 {
+		b.errors++
+	}
+}
+
+// check returns why the run should be aborted, or an empty string
+func (g *failureGuard) check() string {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	now := time.Now().Unix()
+	requests, errors := 0, 0
+	for _, b := range g.buckets {
+		if now-b.second < int64(len(g.buckets)) {
+			requests += b.requests
+			errors += b.errors
+		}
+	}
+	if g.maxErrors > 0 && errors > g.maxErrors {
+		return fmt.Sprintf("%d errors in the last %v (allowed: %d)", errors, g.window, g.maxErrors)
+	}
+	if g.maxErrorRate > 0 && requests >= failMinRequests {
+		rate := float64(errors) / float64(requests)
+		if rate > g.maxErrorRate {
+			return fmt.Sprintf("error rate %.2f%% in the last %v (%d/%d requests, allowed: %.2f%%)",
+				rate*100, g.window, errors, requests, g.maxErrorRate*100)
+		}
+	}
+	return ""
+}
+
+// watch checks the window every second until ctx is done,
+// and calls abort when a rule is broken.
+func (g *failureGuard) watch(ctx context.Context, abort context.CancelFunc) {
+	if !g.enabled() {
+		return
+	}
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		if reason := g.check(); reason != "" {
+			g.mu.Lock()
+			g.reason = reason
+			g.mu.Unlock()
+			log.Printf("Aborting the benchmark: %s", reason)
+			abort()
+			return
+		}
+	}
+}
+
+func (g *failureGuard) abortReason() string {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.reason
+}
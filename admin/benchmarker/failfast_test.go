@@ -0,0 +1,76 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func newTestGuard(maxErrorRate float64, maxErrors int) *failureGuard {
+	g := &failureGuard{window: 10 * time.Second, maxErrorRate: maxErrorRate, maxErrors: maxErrors}
+	g.reset()
+	return g
+}
+
+func (g *failureGuard) recordN(n int, status int) {
+	for i := 0; i < n; i++ {
+		g.record(status)
+	}
+}
+
+func TestFailureGuardErrorRate(t *testing.T) {
+	g := newTestGuard(0.5, 0)
+	g.recordN(failMinRequests-1, 500)
+	if reason := g.check(); reason != "" {
+		t.Errorf("%d requests, all failed: aborted (%s), want no abort below %d requests", failMinRequests-1, reason, failMinRequests)
+	}
+
+	g = newTestGuard(0.5, 0)
+	g.recordN(30, 200)
+	g.recordN(30, 500)
+	if reason := g.check(); reason != "" {
+		t.Errorf("error rate 50%%: aborted (%s), want no abort at the threshold", reason)
+	}
+	g.record(404)
+	if g.check() == "" {
+		t.Error("error rate above 50%: want an abort")
+	}
+}
+
+func TestFailureGuardMaxErrors(t *testing.T) {
+	g := newTestGuard(0, 5)
+	g.recordN(100, 200)
+	g.recordN(5, statusTimeout)
+	if reason := g.check(); reason != "" {
+		t.Errorf("5 errors: aborted (%s), want no abort", reason)
+	}
+	g.record(500)
+	if g.check() == "" {
+		t.Error("6 errors: want an abort")
+	}
+}
+
+func TestFailureGuardWindow(t *testing.T) {
+	g := newTestGuard(0, 5)
+	old := time.Now().Unix() - int64(len(g.buckets))
+	g.buckets[old%int64(len(g.buckets))] = errorBucket{second: old, requests: 100, errors: 100}
+	if reason := g.check(); reason != "" {
+		t.Errorf("errors older than the window: aborted (%s), want them ignored", reason)
+	}
+}
+
+func TestFailureGuardDisabled(t *testing.T) {
+	g := newTestGuard(0, 0)
+	if g.enabled() {
+		t.Error("no rule set: want the guard disabled")
+	}
+	g.recordN(100, 500)
+	if reason := g.check(); reason != "" {
+		t.Errorf("no rule set: aborted (%s)", reason)
+	}
+
+	g = &failureGuard{maxErrors: 5}
+	g.record(500)
+	if g.enabled() || g.check() != "" {
+		t.Error("no window: want the guard disabled")
+	}
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+)
+
+// fixture holds the initial data the benchmarker otherwise reads from MySQL,
+// so that it can run from a host with HTTP access only.
+type fixture struct {
+	Users    []fixtureUser    `json:"users"`
+	Products []fixtureProduct `json:"products"`
+}
+
+type fixtureUser struct {
+	ID       int    `json:"id"`
+	Email    string `json:"email"`
+	Password string `json:"password"`
+	TotalPay int    `json:"total_pay"` // right after GET /initialize
+}
+
+type fixtureProduct struct {
+	ID       int `json:"id"`
+	Price    int `json:"price"`
+	Comments int `json:"comments"` // right after GET /initialize
+}
+
+// Loaded by --fixture; nil means the data is read from MySQL
+var fixtureData *fixture
+
+func loadFixture(path string) (*fixture, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	f := &fixture{}
+	if err := json.Unmarshal(b, f); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	// Every user and product the scenarios pick from must be there
+	users := map[int]bool{}
+	for _, u := range f.Users {
+		users[u.ID] = true
+	}
+	for id := 1; id <= 5000; id++ {
+		if !users[id] {
+			return nil, fmt.Errorf("%s: user %d is missing", path, id)
+		}
+	}
+	products := map[int]bool{}
+	for _, p := range f.Products {
+		products[p.ID] = p.Price > 0
+	}
+	for id := 1; id <= 10000; id++ {
+		if !products[id] {
+			return nil, fmt.Errorf("%s: product %d is missing or has no price", path, id)
+		}
+	}
+	commented := false
+	for _, p := range f.Products {
+		commented = commented || p.Comments > 0
+	}
+	if !commented {
+		return nil, fmt.Errorf("%s: no comment counts (generate the fixture again with --generate-fixture)", path)
+	}
+	return f, nil
+}
+
+// queryTotalPays sums the initial purchases of every user who has any
+func queryTotalPays(db *sql.DB) (map[int]int, error) {
+	rows, err := db.Query(`
+    SELECT h.user_id, SUM(p.price)
+    FROM histories as h
+    INNER JOIN products as p
+    ON p.id = h.product_id
+    WHERE h.id <= 500000
+    GROUP BY h.user_id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	totals := map[int]int{}
+	for rows.Next() {
+		var id, total int
+		if err := rows.Scan(&id, &total); err != nil {
+			return nil, err
+		}
+		totals[id] = total
+	}
+	return totals, rows.Err()
+}
+
+// queryCommentCounts counts the initial comments of every product that has any
+func queryCommentCounts(db *sql.DB) (map[int]int, error) {
+	rows, err := db.Query("SELECT product_id, COUNT(*) FROM comments WHERE id <= 200000 GROUP BY product_id")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	counts := map[int]int{}
+	for rows.Next() {
+		var id, count int
+		if err := rows.Scan(&id, &count); err != nil {
+			return nil, err
+		}
+		counts[id] = count
+	}
+	return counts, rows.Err()
+}
+
+// generateFixtureFile dumps the initial users and products from MySQL into path.
+// Rows added after GET /initialize are left out.
+func generateFixtureFile(path string) error {
+	db, err := getDB()
+	if err != nil {
+		return err
+	}
+
+	f := fixture{}
+	totals, err := queryTotalPays(db)
+	if err != nil {
+		return err
+	}
+	counts, err := queryCommentCounts(db)
+	if err != nil {
+		return err
+	}
+
+	rows, err := db.Query("SELECT id, email, password FROM users WHERE id <= 5000 ORDER BY id")
+	if err != nil {
+		return err
+	}
+	for rows.Next() {
+		var u fixtureUser
+		if err := rows.Scan(&u.ID, &u.Email, &u.Password); err != nil {
+			rows.Close()
+			return err
+		}
+		u.TotalPay = totals[u.ID]
+		f.Users = append(f.Users, u)
+	}
+	rows.Close()
+
+	rows, err = db.Query("SELECT id, price FROM products WHERE id <= 10000 ORDER BY id")
+	if err != nil {
+		return err
+	}
+	for rows.Next() {
+		var p fixtureProduct
+		if err := rows.Scan(&p.ID, &p.Price); err != nil {
+			rows.Close()
+			return err
+		}
+		p.Comments = counts[p.ID]
+		f.Products = append(f.Products, p)
+	}
+	rows.Close()
+
+	b, err := json.Marshal(f)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, b, 0644)
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestLoadFixture(t *testing.T) {
+	complete := func() *fixture {
+		f := &fixture{}
+		for id := 1; id <= 5000; id++ {
+			f.Users = append(f.Users, fixtureUser{ID: id, Email: "u@example.com", Password: "p"})
+		}
+		for id := 1; id <= 10000; id++ {
+			f.Products = append(f.Products, fixtureProduct{ID: id, Price: 100, Comments: 20})
+		}
+		return f
+	}
+	for _, tt := range []struct {
+		name   string
+		change func(f *fixture)
+		err    string // part of the error, empty if the fixture is valid
+	}{
+		{"complete", func(f *fixture) {}, ""},
+		{"missing user", func(f *fixture) { f.Users = append(f.Users[:41], f.Users[42:]...) }, "user 42 is missing"},
+		{"missing product", func(f *fixture) { f.Products = f.Products[:9999] }, "product 10000 is missing"},
+		{"missing price", func(f *fixture) { f.Products[6].Price = 0 }, "product 7 is missing or has no price"},
+		{"no comment counts", func(f *fixture) {
+			for i := range f.Products {
+				f.Products[i].Comments = 0
+			}
+		}, "no comment counts"},
+	} {
+		f := complete()
+		tt.change(f)
+		b, err := json.Marshal(f)
+		if err != nil {
+			t.Fatal(err)
+		}
+		path := writeTempFile(t, string(b))
+		_, err = loadFixture(path)
+		os.Remove(path)
+		switch {
+		case tt.err == "" && err != nil:
+			t.Errorf("%s: %v", tt.name, err)
+		case tt.err != "" && (err == nil || !strings.Contains(err.Error(), tt.err)):
+			t.Errorf("%s: got error %v, want %q", tt.name, err, tt.err)
+		}
+	}
+}
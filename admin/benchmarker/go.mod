@@ -1,4 +1,4 @@
-module main
+module benchmarker
 
 go 1.13
 
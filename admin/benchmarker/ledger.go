@@ -0,0 +1,262 @@
+package main
+
+import (
+	"context"
+	"math/rand"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+// purchaseLedger remembers every purchase the benchmarker made so that
+// GET /users/:id can be checked for lost or duplicated histories.
+type purchaseLedger struct {
+	mu    sync.Mutex
+	users map[int]*userLedger
+	checkTally
+}
+
+type userLedger struct {
+	baseline int // total right after GET /initialize, see getTotalPay
+	pendingWrites
+	products []int // successful purchases, oldest first; guarded by mu
+}
+
+// pendingWrites follows the purchases of a user or the comments on a product
+// while they are sent, so that a page is only compared with a ledger when no
+// write could change it meanwhile.
+type pendingWrites struct {
+	mu       sync.Mutex
+	inFlight int
+	overlaps int  // pairs of writes that were in flight at the same time
+	version  int  // bumped whenever a write starts or ends
+	unsure   bool // a write failed, so it may or may not have been stored
+}
+
+// begin must be called before the write is sent
+func (w *pendingWrites) begin() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.overlaps += w.inFlight
+	w.inFlight++
+	w.version++
+}
+
+// finish ends a write started with begin; record adds it to the ledger, under mu
+func (w *pendingWrites) finish(ok bool, record func()) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.inFlight--
+	w.version++
+	if ok {
+		record()
+	} else {
+		w.unsure = true
+	}
+}
+
+// verify fetches path and compares it with the ledger, adding the outcome to
+// tally. snapshot copies what check needs from the ledger, under mu, and
+// reports whether there is anything to check. verify returns false when the
+// check was skipped because writes were in flight or, unless final is set,
+// because the page could not be fetched.
+func (w *pendingWrites) verify(ctx context.Context, tally *checkTally, path string, final bool,
+	snapshot func() bool, check func(*goquery.Document) []validationFailure) bool {
+	w.mu.Lock()
+	if w.unsure || w.inFlight > 0 || !snapshot() {
+		w.mu.Unlock()
+		return false
+	}
+	version := w.version
+	w.mu.Unlock()
+
+	doc, failures := fetchDocument(ctx, path)
+	if doc == nil && (!final || ctx.Err() != nil) {
+		// During the load phase a failed request is already scored
+		return false
+	}
+
+	if doc != nil {
+		// Writes that started meanwhile make the page and the ledger incomparable
+		w.mu.Lock()
+		changed := w.version != version
+		w.mu.Unlock()
+		if changed {
+			return false
+		}
+		failures = check(doc)
+	}
+
+	tally.add(failures)
+	return true
+}
+
+// Verify at most this many users or products when the run ends
+const maxFinalChecks = 50
+
+// finalChecks picks the ids to verify when the run ends
+func finalChecks(ids []int) []int {
+	sort.Ints(ids)
+	rand.Shuffle(len(ids), func(i, j int) { ids[i], ids[j] = ids[j], ids[i] })
+	if len(ids) > maxFinalChecks {
+		ids = ids[:maxFinalChecks]
+	}
+	return ids
+}
+
+var ledger = &purchaseLedger{
+	checkTally: checkTally{label: "Inconsistent purchase history"},
+	users:      map[int]*userLedger{},
+}
+
+func (l *purchaseLedger) user(userID int) *userLedger {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	u, ok := l.users[userID]
+	if !ok {
+		u = &userLedger{baseline: getTotalPay(userID)}
+		l.users[userID] = u
+	}
+	return u
+}
+
+// begin must be called before POST /products/buy/:id is sent
+func (l *purchaseLedger) begin(userID int) {
+	l.user(userID).begin()
+}
+
+// finish records the outcome of a purchase started with begin
+func (l *purchaseLedger) finish(userID int, productID int, ok bool) {
+	u := l.user(userID)
+	u.finish(ok, func() { u.products = append(u.products, productID) })
+}
+
+var totalPayAmount = regexp.MustCompile(`^合計金額: ([0-9]+)円$`)
+
+// verify compares GET /users/:id against the ledger; see pendingWrites.verify
+func (l *purchaseLedger) verify(ctx context.Context, userID int, final bool) bool {
+	u := l.user(userID)
+	path := "/users/" + strconv.Itoa(userID)
+	var (
+		products []int
+		slack    int
+	)
+	return u.verify(ctx, &l.checkTally, path, final, func() bool {
+		products = append([]int{}, u.products...)
+		slack = u.overlaps
+		return len(products) > 0
+	}, func(doc *goquery.Document) []validationFailure {
+		return checkUserLedger(doc, "GET "+path, u.baseline, products, slack)
+	})
+}
+
+// checkUserLedger compares a user page with the purchases in the ledger.
+// The page lists the latest 30 purchases in the order they were stored, the
+// ledger in the order their responses came back; the two differ among
+// overlapping purchases, at most slack places apart.
+func checkUserLedger(doc *goquery.Document, endpoint string, baseline int, products []int, slack int) []validationFailure {
+	var failures []validationFailure
+	fail := failuresOf(endpoint, &failures)
+
+	n := len(products)
+	if n > 30 {
+		n = 30
+	}
+	var actualTop []int
+	doc.Find(".panel-heading a").EachWithBreak(func(i int, s *goquery.Selection) bool {
+		href, _ := s.Attr("href")
+		id, _ := strconv.Atoi(strings.TrimPrefix(href, "/products/"))
+		actualTop = append(actualTop, id)
+		return i+1 < n
+	})
+	// With more than 30 purchases, the ones on the page must be among the
+	// latest of the ledger, give or take the overlapping ones
+	window := n
+	if len(products) > n {
+		window += slack
+		if window > len(products) {
+			window = len(products)
+		}
+	}
+	recent := append([]int{}, products[len(products)-window:]...)
+	sort.Ints(recent)
+	sort.Ints(actualTop)
+	if len(actualTop) != n || !containsInts(recent, actualTop) {
+		check := "latest " + strconv.Itoa(n) + " purchases"
+		if window > n {
+			check += " (out of the latest " + strconv.Itoa(window) + ")"
+		}
+		fail(check, formatInts(recent), formatInts(actualTop))
+	}
+
+	expectedTotal := baseline + sumPrices(products)
+	actualText := doc.Find(".container h4").First().Text()
+	m := totalPayAmount.FindStringSubmatch(actualText)
+	if m == nil {
+		fail("total purchase amount", "合計金額: "+strconv.Itoa(expectedTotal)+"円", actualText)
+	} else if actual, _ := strconv.Atoi(m[1]); actual < expectedTotal {
+		fail("total purchase amount (lost purchases)", strconv.Itoa(expectedTotal), m[1])
+	} else if actual > expectedTotal {
+		fail("total purchase amount (duplicated purchases)", strconv.Itoa(expectedTotal), m[1])
+	}
+	return failures
+}
+
+// verifyAll checks up to maxFinalChecks users the benchmarker bought for.
+// A user page that cannot be fetched fails the check.
+func (l *purchaseLedger) verifyAll() {
+	l.mu.Lock()
+	ids := make([]int, 0, len(l.users))
+	for id := range l.users {
+		ids = append(ids, id)
+	}
+	l.mu.Unlock()
+
+	for _, id := range finalChecks(ids) {
+		l.verify(context.Background(), id, true)
+	}
+}
+
+// expectedTotal is the total purchase amount GET /users/:id should show
+func (l *purchaseLedger) expectedTotal(userID int) int {
+	u := l.user(userID)
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	return u.baseline + sumPrices(u.products)
+}
+
+func sumPrices(productIDs []int) int {
+	total := 0
+	for _, id := range productIDs {
+		total += getProductPrice(id)
+	}
+	return total
+}
+
+// containsInts reports whether every element of sub is in set, as many
+// times as in sub; both must be sorted
+func containsInts(set, sub []int) bool {
+	i := 0
+	for _, v := range sub {
+		for i < len(set) && set[i] < v {
+			i++
+		}
+		if i == len(set) || set[i] != v {
+			return false
+		}
+		i++
+	}
+	return true
+}
+
+func formatInts(a []int) string {
+	s := make([]string, len(a))
+	for i, v := range a {
+		s[i] = strconv.Itoa(v)
+	}
+	return strings.Join(s, ",")
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+// usePrices makes getProductPrice return id*10 instead of reading MySQL
+func usePrices(t *testing.T) {
+	productPricesOnce.Do(func() {
+		productPrices = map[int]int{}
+		for id := 1; id <= 10000; id++ {
+			productPrices[id] = id * 10
+		}
+	})
+	if getProductPrice(1) != 10 {
+		t.Fatal("product prices were loaded before the test")
+	}
+}
+
+// userPage renders a page shaped like views/mypage.ejs; ids are newest first
+func userPage(t *testing.T, total int, ids []int) *goquery.Document {
+	var b strings.Builder
+	b.WriteString(`<html><body><div class="jumbotron"><div class="container"><h2>ユーザー さんの購入履歴</h2>`)
+	if total >= 0 {
+		fmt.Fprintf(&b, `<h4>合計金額: %d円</h4>`, total)
+	}
+	b.WriteString(`</div></div><div class="container"><div class="row">`)
+	for i, id := range ids {
+		if i >= 30 {
+			break
+		}
+		fmt.Fprintf(&b, `<div class="col-md-4"><div class="panel panel-default"><div class="panel-heading">`+
+			`<a href="/products/%d">商品%d</a></div><div class="panel-body"><h4>価格</h4><p>%d円</p></div></div></div>`, id, id, id*10)
+	}
+	b.WriteString(`</div></div></body></html>`)
+	doc, err := goquery.NewDocumentFromReader(strings.NewReader(b.String()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return doc
+}
+
+// reversed returns the purchases newest first, followed by older ones
+func reversed(ids []int, older ...int) []int {
+	r := make([]int, 0, len(ids)+len(older))
+	for i := len(ids) - 1; i >= 0; i-- {
+		r = append(r, ids[i])
+	}
+	return append(r, older...)
+}
+
+func TestCheckUserLedger(t *testing.T) {
+	usePrices(t)
+	const baseline = 100000
+	initial := []int{9001, 9002, 9003} // bought before the benchmark
+
+	var many []int // 32 purchases as the ledger has them
+	for i := 1; i <= 32; i++ {
+		many = append(many, 100+i)
+	}
+	// The second and the third purchase overlapped, and were stored the other way around
+	stored := append([]int{many[0], many[2], many[1]}, many[3:]...)
+
+	for _, tt := range []struct {
+		name     string
+		products []int
+		slack    int
+		total    int   // -1 leaves the total out
+		page     []int // newest first
+		checks   []string
+	}{
+		{"matching", []int{5, 7, 5}, 0, baseline + 170, reversed([]int{5, 7, 5}, initial...), nil},
+		{"reordered", []int{5, 7, 8}, 1, baseline + 200, []int{7, 8, 5, 9001}, nil},
+		{"lost", []int{5, 7, 8}, 0, baseline + 120, reversed([]int{5, 7}, initial...),
+			[]string{"latest 3 purchases", "total purchase amount (lost purchases)"}},
+		{"duplicated", []int{5, 7}, 0, baseline + 170, reversed([]int{5, 5, 7}, initial...),
+			[]string{"total purchase amount (duplicated purchases)"}},
+		{"duplicated in the list", []int{5, 7}, 0, baseline + 120, []int{7, 7, 9001},
+			[]string{"latest 2 purchases"}},
+		{"missing total", []int{5}, 0, -1, reversed([]int{5}, initial...), []string{"total purchase amount"}},
+		{"more than 30", many, 0, baseline + sumPrices(many), reversed(many), nil},
+		{"overlap at the boundary", many, 1, baseline + sumPrices(many), reversed(stored), nil},
+		{"overlap at the boundary without slack", many, 0, baseline + sumPrices(many), reversed(stored),
+			[]string{"latest 30 purchases"}},
+		{"lost beyond the slack", many, 1, baseline + sumPrices(many), reversed(many[3:], 9001),
+			[]string{"latest 30 purchases (out of the latest 31)"}},
+	} {
+		failures := checkUserLedger(userPage(t, tt.total, tt.page), "GET /users/1", baseline, tt.products, tt.slack)
+		var checks []string
+		for _, f := range failures {
+			checks = append(checks, f.Check)
+		}
+		if strings.Join(checks, "; ") != strings.Join(tt.checks, "; ") {
+			t.Errorf("%s: failed %q, want %q", tt.name, checks, tt.checks)
+		}
+	}
+}
+
+func TestContainsInts(t *testing.T) {
+	for _, tt := range []struct {
+		set, sub []int
+		want     bool
+	}{
+		{[]int{1, 2, 3}, []int{1, 3}, true},
+		{[]int{1, 2, 2, 3}, []int{2, 2}, true},
+		{[]int{1, 2, 3}, []int{2, 2}, false},
+		{[]int{1, 2, 3}, []int{4}, false},
+		{nil, nil, true},
+	} {
+		if got := containsInts(tt.set, tt.sub); got != tt.want {
+			t.Errorf("containsInts(%v, %v) = %v, want %v", tt.set, tt.sub, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"context"
+	"log"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// loadMonitor counts requests, errors and latency since the last snapshot.
+type loadMonitor struct {
+	mu       sync.Mutex
+	requests int
+	errors   int
+	latency  time.Duration
+}
+
+type loadWindow struct {
+	requests   int
+	errors     int
+	avgLatency time.Duration
+}
+
+var monitor = new(loadMonitor)
+
+func (l *loadMonitor) record(status int, elapsed time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.requests++
+	if status >= 400 {
+		l.errors++
+	}
+	l.latency += elapsed
+}
+
+// snapshot returns the counters of the current window and starts a new one
+func (l *loadMonitor) snapshot() loadWindow {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	w := loadWindow{requests: l.requests, errors: l.errors}
+	if l.requests > 0 {
+		w.avgLatency = l.latency / time.Duration(l.requests)
+	}
+	l.requests, l.errors, l.latency = 0, 0, 0
+	return w
+}
+
+func (w loadWindow) errorRate() float64 {
+	if w.requests == 0 {
+		return 0
+	}
+	return float64(w.errors) / float64(w.requests)
+}
+
+// loadController starts cfg.workload workers and, when ramp-up is enabled,
+// adds cfg.rampStep more every cfg.rampInterval as long as the last window
+// stayed under the error rate and latency thresholds.
+type loadController struct {
+	cfg     benchConfig
+	picker  *weightedPicker
+	wg      *sync.WaitGroup
+	ctx     context.Context
+	seeds   *rand.Rand // the seed of each worker's agent, drawn in spawn order
+	workers int        // workers started, or the most users active at once in the open model
+
+	mu       sync.Mutex
+	active   int // users of the open model still running their scenario
+	arrivals int
+	dropped  int
+}
+
+func (lc *loadController) spawn(n int) {
+	for i := 0; i < n && lc.workers < lc.cfg.maxWorkload; i++ {
+		a := newAgent(lc.seeds.Int63())
+		a.id = lc.workers + 1
+		lc.wg.Add(1)
+		go loopScenario(lc.ctx, lc.wg, a, lc.picker.next())
+		lc.workers++
+	}
+}
+
+func (lc *loadController) run() {
+	defer lc.wg.Done()
+	if lc.cfg.arrivalRate > 0 {
+		lc.arrive()
+		return
+	}
+	monitor.snapshot()
+	lc.spawn(lc.cfg.workload)
+	if lc.cfg.rampInterval <= 0 {
+		return
+	}
+
+	ticker := time.NewTicker(lc.cfg.rampInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-lc.ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		w := monitor.snapshot()
+		if lc.workers >= lc.cfg.maxWorkload {
+			continue
+		}
+		if w.requests == 0 || w.errorRate() > lc.cfg.rampMaxErrorRate || w.avgLatency > lc.cfg.rampMaxLatency {
+			log.Printf("Ramp-up: holding at %d workers (requests=%d, error rate=%.2f%%, avg latency=%v)",
+				lc.workers, w.requests, w.errorRate()*100, w.avgLatency)
+			continue
+		}
+		lc.spawn(lc.cfg.rampStep)
+		log.Printf("Ramp-up: %d workers (error rate=%.2f%%, avg latency=%v)",
+			lc.workers, w.errorRate()*100, w.avgLatency)
+	}
+}
+
+// arrive starts users at the times of a Poisson process of rate
+// cfg.arrivalRate until the end of the load phase. Unlike the looping
+// workers, new users keep coming when the target slows down.
+func (lc *loadController) arrive() {
+	gaps := rand.New(rand.NewSource(lc.seeds.Int63()))
+	for {
+		gap := time.Duration(gaps.ExpFloat64() / lc.cfg.arrivalRate * float64(time.Second))
+		if sleepContext(lc.ctx, gap) {
+			return
+		}
+		lc.mu.Lock()
+		lc.arrivals++
+		if lc.active >= lc.cfg.maxUsers {
+			lc.dropped++
+			lc.mu.Unlock()
+			continue
+		}
+		lc.active++
+		if lc.active > lc.workers {
+			lc.workers = lc.active
+		}
+		lc.mu.Unlock()
+
+		a := newAgent(lc.seeds.Int63())
+		a.id = lc.arrivals
+		name := lc.picker.next()
+		lc.wg.Add(1)
+		go func() {
+			defer lc.wg.Done()
+			runScenario(lc.ctx, a, name, scenarios[name])
+			lc.mu.Lock()
+			lc.active--
+			lc.mu.Unlock()
+		}()
+	}
+}
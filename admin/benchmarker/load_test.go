@@ -0,0 +1,102 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// useTestFixture makes the initial data come from a fixture instead of MySQL
+func useTestFixture() {
+	if fixtureData != nil {
+		return
+	}
+	f := &fixture{}
+	for id := 1; id <= 5000; id++ {
+		f.Users = append(f.Users, fixtureUser{ID: id, Email: fmt.Sprintf("user%d@example.com", id), Password: "password"})
+	}
+	for id := 1; id <= 10000; id++ {
+		f.Products = append(f.Products, fixtureProduct{ID: id, Price: id * 10, Comments: 20})
+	}
+	fixtureData = f
+}
+
+// requestLog is a transport that answers every request with an empty page
+// and remembers what was asked
+type requestLog struct {
+	mu       sync.Mutex
+	requests []string
+}
+
+func (l *requestLog) RoundTrip(req *http.Request) (*http.Response, error) {
+	var body []byte
+	if req.Body != nil {
+		body, _ = ioutil.ReadAll(req.Body)
+		req.Body.Close()
+	}
+	l.mu.Lock()
+	l.requests = append(l.requests, req.Method+" "+req.URL.RequestURI()+" "+string(body))
+	l.mu.Unlock()
+	return &http.Response{
+		StatusCode: http.StatusOK,
+		Header:     http.Header{"Content-Type": {"text/html"}},
+		Body:       ioutil.NopCloser(strings.NewReader("<html><body></body></html>")),
+		Request:    req,
+	}, nil
+}
+
+// runSeeded runs a few scenarios for each of workers agents, drawing their
+// seeds and scenarios the way loadController.spawn does, and returns the
+// requests each agent sent
+func runSeeded(seed int64, workers int) [][]string {
+	seeds := rand.New(rand.NewSource(seed))
+	picker := weightedList{{"just", 1}, {"stalker", 1}, {"bakugai", 1}}.picker()
+	var sent [][]string
+	for i := 0; i < workers; i++ {
+		a := newAgent(seeds.Int63())
+		a.id = i + 1
+		log := &requestLog{}
+		a.client.Transport = log
+		name := picker.next()
+		for j := 0; j < 3; j++ {
+			runScenario(context.Background(), a, name, scenarios[name])
+		}
+		sent = append(sent, log.requests)
+	}
+	return sent
+}
+
+func TestSameSeedSameRequests(t *testing.T) {
+	useTestFixture()
+	var err error
+	if balancer, err = newTargetBalancer(balanceRoundRobin, weightedList{{"http://bench.test", 1}}); err != nil {
+		t.Fatal(err)
+	}
+	// The checks of the ledgers go through the shared transport; keep them off the network
+	defer func(next http.RoundTripper) { targetTransport.next = next }(targetTransport.next)
+	targetTransport.next = &requestLog{}
+	scores = newScoreAggregator()
+	defer scores.finalize()
+	defer content.reset()
+
+	first := runSeeded(42, 3)
+	second := runSeeded(42, 3)
+	for i := range first {
+		if len(first[i]) == 0 {
+			t.Fatalf("agent %d sent no request", i+1)
+		}
+		if !reflect.DeepEqual(first[i], second[i]) {
+			t.Errorf("agent %d: the same seed sent different requests:\n%s\nthen\n%s",
+				i+1, strings.Join(first[i], "\n"), strings.Join(second[i], "\n"))
+		}
+	}
+	if other := runSeeded(43, 3); reflect.DeepEqual(first, other) {
+		t.Error("another seed sent the same requests")
+	}
+}
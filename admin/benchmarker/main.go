@@ -1,100 +1,542 @@
 package main
 
 import (
+	"context"
 	"database/sql"
 	"flag"
 	"fmt"
 	"log"
+	"math/rand"
 	"os"
 	"strconv"
+	"strings"
 	"sync"
 	"time"
 
 	_ "github.com/go-sql-driver/mysql"
 )
 
-func loopJustLookingScenario(wg *sync.WaitGroup, m *sync.Mutex, finishTime time.Time) {
+func loopScenario(ctx context.Context, wg *sync.WaitGroup, a *agent, name string) {
+	defer wg.Done()
+	def := scenarios[name]
 	for {
-		if justLookingScenario(wg, m, finishTime) {
+		if runScenario(ctx, a, name, def) {
 			return
 		}
 	}
 }
 
-func loopStalkerScenario(wg *sync.WaitGroup, m *sync.Mutex, finishTime time.Time) {
-	for {
-		if stalkerScenario(wg, m, finishTime) {
-			return
+func getEnv(key, fallback string) string {
+	if value, ok := os.LookupEnv(key); ok {
+		return value
+	}
+	return fallback
+}
+
+func getEnvInt(key string, fallback int) int {
+	if value, ok := os.LookupEnv(key); ok {
+		if i, err := strconv.Atoi(value); err == nil {
+			return i
 		}
+		log.Printf("Ignoring invalid %s=%q", key, value)
 	}
+	return fallback
 }
 
-func loopBakugaiScenario(wg *sync.WaitGroup, m *sync.Mutex, finishTime time.Time) {
-	for {
-		if bakugaiScenario(wg, m, finishTime) {
-			return
+func getEnvFloat(key string, fallback float64) float64 {
+	if value, ok := os.LookupEnv(key); ok {
+		if f, err := strconv.ParseFloat(value, 64); err == nil {
+			return f
 		}
+		log.Printf("Ignoring invalid %s=%q", key, value)
 	}
+	return fallback
 }
 
-func getEnv(key, fallback string) string {
+func getEnvBool(key string, fallback bool) bool {
 	if value, ok := os.LookupEnv(key); ok {
-		return value
+		if b, err := strconv.ParseBool(value); err == nil {
+			return b
+		}
+		log.Printf("Ignoring invalid %s=%q", key, value)
 	}
 	return fallback
 }
 
+func getEnvDuration(key string, fallback time.Duration) time.Duration {
+	if value, ok := os.LookupEnv(key); ok {
+		if d, err := time.ParseDuration(value); err == nil {
+			return d
+		}
+		log.Printf("Ignoring invalid %s=%q", key, value)
+	}
+	return fallback
+}
+
+var (
+	dbOnce   sync.Once
+	sharedDB *sql.DB
+	dbErr    error
+)
+
+// getDB returns the connection pool shared by every goroutine; do not Close it
 func getDB() (*sql.DB, error) {
-	user := getEnv("ISHOCON1_DB_USER", "ishocon")
-	pass := getEnv("ISHOCON1_DB_PASSWORD", "ishocon")
-	host := getEnv("ISHOCON1_DB_HOST", "localhost")
-	port := getEnv("ISHOCON1_DB_PORT", "3306")
-	dbname := getEnv("ISHOCON1_DB_NAME", "ishocon1")
-	db, err := sql.Open("mysql", user+":"+pass+"@tcp("+host+":"+port+")/"+dbname)
-	return db, err
+	dbOnce.Do(func() {
+		user := getEnv("ISHOCON1_DB_USER", "ishocon")
+		pass := getEnv("ISHOCON1_DB_PASSWORD", "ishocon")
+		host := getEnv("ISHOCON1_DB_HOST", "localhost")
+		port := getEnv("ISHOCON1_DB_PORT", "3306")
+		dbname := getEnv("ISHOCON1_DB_NAME", "ishocon1")
+		sharedDB, dbErr = sql.Open("mysql", user+":"+pass+"@tcp("+host+":"+port+")/"+dbname)
+		if dbErr != nil {
+			return
+		}
+		sharedDB.SetMaxOpenConns(8)
+		sharedDB.SetMaxIdleConns(8)
+		sharedDB.SetConnMaxLifetime(5 * time.Minute)
+	})
+	return sharedDB, dbErr
+}
+
+func startBenchmark(cfg benchConfig) {
+	result := beginBenchmark(cfg)
+	// Every in-flight request is cancelled when the load phase ends
+	ctx, cancel := context.WithDeadline(context.Background(), result.StartTime.Add(cfg.duration))
+	defer cancel()
+
+	report := runLoadPhase(ctx, cancel, cfg)
+	finishBenchmark(cfg, result, report)
 }
 
-func startBenchmark(workload int) {
+// beginBenchmark initializes and validates the app. It exits when the validation fails.
+func beginBenchmark(cfg benchConfig) benchResult {
 	getInitialize()
-	log.Print("Benchmark Start!  Workload: " + strconv.Itoa(workload))
-	finishTime := time.Now().Add(1 * time.Minute)
-	validateInitialize()
+	log.Print("Benchmark Start!  Workload: " + strconv.Itoa(cfg.workload))
+	log.Printf("Duration: %v, Mix: %s, Seed: %d", cfg.duration, cfg.mix, cfg.seed)
+	if cfg.arrivalRate > 0 {
+		log.Printf("Open model: %g users/s arriving, at most %d at once", cfg.arrivalRate, cfg.maxUsers)
+	}
+	log.Printf("Think time: %v", defaultThinkTime)
+	if cfg.rampInterval > 0 {
+		log.Printf("Ramp-up: +%d workers every %v up to %d (max error rate=%.2f%%, max avg latency=%v)",
+			cfg.rampStep, cfg.rampInterval, cfg.maxWorkload, cfg.rampMaxErrorRate*100, cfg.rampMaxLatency)
+	}
+	if failGuard.enabled() {
+		log.Printf("Fail-fast: abort when errors exceed %.2f%% or %d in %v (0 = no limit)",
+			failGuard.maxErrorRate*100, failGuard.maxErrors, failGuard.window)
+	}
+	// Read everything needed from MySQL (or the fixture) before the clock starts
+	loadUsers()
+	getProductPrice(0)
+	getTotalPay(0)
+	getCommentCount(0)
+
+	result := newResult(cfg)
+
+	// Pass/fail of the validation phase is decided here only
+	failures := validateInitialize()
+	result.Validation = validationResult{Passed: len(failures) == 0, Failures: failures}
+	if len(failures) > 0 {
+		showValidationFailures(failures)
+		log.Print("Benchmark Failed! Score: 0")
+		saveResult(cfg, result)
+		os.Exit(1)
+	}
+	return result
+}
+
+// runLoadPhase runs the scenario workers until ctx is done, then checks the
+// purchase histories and comments they left behind.
+func runLoadPhase(ctx context.Context, cancel context.CancelFunc, cfg benchConfig) loadReport {
 	wg := new(sync.WaitGroup)
-	m := new(sync.Mutex)
-	for i := 0; i < workload; i++ {
-		wg.Add(1)
-		if i%3 == 0 {
-			go loopJustLookingScenario(wg, m, finishTime)
-		} else if i%3 == 1 {
-			go loopStalkerScenario(wg, m, finishTime)
-		} else {
-			go loopBakugaiScenario(wg, m, finishTime)
-		}
+	scores = newScoreAggregator()
+	lc := &loadController{
+		cfg:    cfg,
+		picker: cfg.mix.picker().dealt(share.index, share.count),
+		wg:     wg,
+		ctx:    ctx,
+		seeds:  rand.New(rand.NewSource(cfg.seed)),
 	}
+	stats.reset()
+	content.reset()
+	failGuard.reset()
+	go failGuard.watch(ctx, cancel)
+	tracer.begin(time.Now())
+	wg.Add(1)
+	go lc.run()
 	wg.Wait()
+	if err := tracer.close(); err != nil {
+		log.Printf("Failed to write trace: %v", err)
+	}
+
+	// Every worker has stopped, so the score is final
+	report := loadReport{
+		ScenarioScores: scores.finalize(),
+		PeakWorkers:    lc.workers,
+		Arrivals:       lc.arrivals,
+		Dropped:        lc.dropped,
+		AbortReason:    failGuard.abortReason(),
+	}
+
+	log.Print("Checking purchase histories and comments...")
+	ledger.verifyAll()
+	comments.verifyAll()
+
+	report.ContentChecks = content.result()
+	report.PurchaseChecks = ledger.result()
+	report.CommentChecks = comments.result()
+	report.Stats = stats.snapshot()
+	return report
+}
+
+// finishBenchmark scores the load phase, shows and saves the result.
+// stats must hold the statistics of the whole load phase.
+func finishBenchmark(cfg benchConfig, result benchResult, report loadReport) {
+	result.PeakWorkers = report.PeakWorkers
+	result.Arrivals = report.Arrivals
+	result.DroppedArrivals = report.Dropped
+	if report.Dropped > 0 {
+		log.Printf("Open model: %d of %d arriving users were turned away (--max-users %d)",
+			report.Dropped, report.Arrivals, cfg.maxUsers)
+	}
+	result.ContentChecks = report.ContentChecks
+	result.PurchaseChecks = report.PurchaseChecks
+	result.CommentChecks = report.CommentChecks
+	result.Passed = true
+	if report.AbortReason != "" {
+		log.Printf("Benchmark Failed! Aborted: %s", report.AbortReason)
+		result.AbortReason = report.AbortReason
+		result.Passed = false
+	}
+	if result.ContentChecks.Failed > content.maxFailures {
+		log.Printf("Benchmark Failed! %d responses had invalid content (allowed: %d)", result.ContentChecks.Failed, content.maxFailures)
+		result.Passed = false
+	}
+	if result.PurchaseChecks.Failed > 0 {
+		log.Printf("Benchmark Failed! %d purchase histories did not match the purchases made", result.PurchaseChecks.Failed)
+		result.Passed = false
+	}
+	if result.CommentChecks.Failed > 0 {
+		log.Printf("Benchmark Failed! %d products did not show the comments posted", result.CommentChecks.Failed)
+		result.Passed = false
+	}
+
+	totalScore := report.totalScore()
+	if !result.Passed {
+		// Like a failed validation, a failed run scores 0 and is not posted
+		totalScore = 0
+	}
+	showScore(totalScore)
+	showEndpointStats()
+
+	result.Score = totalScore
+	result.ScenarioScores = report.ScenarioScores
+	result.Endpoints, result.Targets, result.Errors = stats.endpointResults()
+	if result.Passed {
+		postScore(totalScore)
+	}
+	saveResult(cfg, result)
+}
+
+// runInit resets the app to its initial data without benchmarking it
+func runInit() {
+	getInitialize()
+	initializeData()
+	log.Print("Initialize completed")
+}
+
+// runValidate runs the validation phase of a benchmark only
+func runValidate() {
+	loadUsers()
+	getProductPrice(0)
+	getTotalPay(0)
+	getCommentCount(0)
+	failures := validateInitialize()
+	if len(failures) > 0 {
+		showValidationFailures(failures)
+		log.Print("Validation Failed!")
+		os.Exit(1)
+	}
+	log.Print("Validation Passed!")
+}
+
+func saveResult(cfg benchConfig, result benchResult) {
+	if cfg.output == "" {
+		return
+	}
+	result.EndTime = time.Now()
+	if err := writeResult(cfg.output, result); err != nil {
+		log.Printf("Failed to write result: %v", err)
+		os.Exit(1)
+	}
+	log.Printf("Wrote result to %s", cfg.output)
 }
 
 var host = "http://127.0.0.1"
-var totalScore = 0
-var finished = false
 
 func main() {
 	flag.Usage = func() {
-		fmt.Println(`Usage: ./benchmark [option]
+		fmt.Println(`Usage: ./benchmark [bench] [option]
+       ./benchmark validate [--ip IP | --target URL] [--fixture FILE]
+       ./benchmark init [--ip IP | --target URL] [--fixture FILE]
+       ./benchmark report RESULT
+       ./benchmark coordinator --workers N [--listen ADDR] [option]
+       ./benchmark worker --coordinator URL [--ip IP | --target URL] [--fixture FILE]
+       ./benchmark replay [--ip IP | --target URL] [--speed X] [--initialize=false] TRACE
+Commands:
+  bench		GET /initialize, validate and run the load (default)
+  validate	only run the validation phase; run init first so the app has its initial data
+  init		only GET /initialize and clean up the data the benchmarker adds
+  report	print a result saved with --output
+  replay	re-send the requests recorded with --record
+  coordinator	like bench, but the load phase is run by N worker processes
+  worker	run the load phase assigned by a coordinator
 Options:
-  --ip IP	specify target ip (default: 127.0.0.1:80)
-Note: workload is fixed to maximum value (5)`)
+  --ip IP		specify target ip (default: 127.0.0.1:80, env: BENCH_IP)
+  --target URL		full target URL with scheme, port and path prefix, e.g. https://example.com:8443/app;
+			overrides --ip (env: BENCH_TARGET)
+			Both take a comma-separated list with optional weights, e.g. 10.0.0.1=2,10.0.0.2=1;
+			the validator and GET /initialize use the first one.
+  --balance MODE	how virtual users are spread over several targets: round-robin or weighted
+			(per session) or sticky (per user) (default: round-robin, env: BENCH_BALANCE)
+  --insecure		do not verify the server certificate (env: BENCH_INSECURE)
+  --ca-cert FILE		trust the PEM certificates in FILE in addition to the system ones (env: BENCH_CA_CERT)
+  --server-name NAME	TLS server name (SNI) to send (default: the --host-header host, env: BENCH_SERVER_NAME)
+  --host-header HOST	Host header to send instead of the one from the URL (env: BENCH_HOST_HEADER)
+  --duration DURATION	length of the load phase, e.g. 10s, 5m (default: 1m, env: BENCH_DURATION)
+  --workload N		number of scenario workers (default: 5, env: BENCH_WORKLOAD)
+  --mix MIX		weighted scenario mix, e.g. just=2,stalker=1,bakugai=3
+			(default: just=1,stalker=1,bakugai=1, env: BENCH_MIX)
+  --output FILE		write the result as JSON to FILE (env: BENCH_OUTPUT)
+  --seed N		seed of the random choices; runs with the same seed send the same requests
+			from each worker (default: 0 = random, env: BENCH_SEED)
+  --fixture FILE		read users, prices, initial totals and comment counts from FILE instead of MySQL (env: BENCH_FIXTURE)
+  --generate-fixture FILE	dump the initial data from MySQL into FILE and exit
+  --content-sample-rate PCT	percentage of index/product/user page responses whose content is checked during load (default: 5, env: BENCH_CONTENT_SAMPLE_RATE)
+  --content-max-failures N	fail the run when more than N checked responses are invalid (default: 10, env: BENCH_CONTENT_MAX_FAILURES)
+  --think-time SPEC	pause of a virtual user after each step without a think time of its own:
+			DURATION, uniform:MIN-MAX or exponential:MEAN, e.g. exponential:500ms
+			(default: 0, env: BENCH_THINK_TIME)
+  --arrival-rate R	open model: R users per second arrive at random (Poisson) and each runs one scenario
+			from --mix; --workload is not used (default: 0 = looping workers, env: BENCH_ARRIVAL_RATE)
+  --max-users N		users active at once in the open model; arrivals beyond are turned away
+			(default: 1000, env: BENCH_MAX_USERS)
+  --ramp-interval DURATION	add workers every DURATION while the target keeps up (default: 0 = disabled, env: BENCH_RAMP_INTERVAL)
+  --ramp-step N			workers added per ramp-up (default: 3, env: BENCH_RAMP_STEP)
+  --max-workload N		upper bound of workers when ramping up (default: 30, env: BENCH_MAX_WORKLOAD)
+  --ramp-max-error-rate PCT	hold the load when the error rate exceeds PCT percent (default: 1, env: BENCH_RAMP_MAX_ERROR_RATE)
+  --ramp-max-latency DURATION	hold the load when the average latency exceeds DURATION (default: 500ms, env: BENCH_RAMP_MAX_LATENCY)
+  --scoring FILE		read endpoint weights and penalties from FILE, see scoring.example.json (env: BENCH_SCORING)
+  --scenarios FILE	add scenarios defined in FILE to --mix, or replace built-in ones of the same name,
+			see scenarios.example.json (env: BENCH_SCENARIOS)
+  --fail-error-rate PCT	abort and fail the run when more than PCT percent of the requests in the window are errors
+			(default: 50, 0 = disabled, env: BENCH_FAIL_ERROR_RATE)
+  --fail-error-count N	abort and fail the run when more than N requests in the window are errors
+			(default: 0 = disabled, env: BENCH_FAIL_ERROR_COUNT)
+  --fail-window DURATION	sliding window of the fail-fast rules (default: 10s, env: BENCH_FAIL_WINDOW)
+  --record FILE		write every request of the load phase to FILE as JSON lines (env: BENCH_RECORD)
+Replay options:
+  --speed X		replay X times faster than recorded; 0 sends requests without waiting (default: 1)
+  --initialize		GET /initialize before replaying (default: true)
+Distributed options:
+  --workers N		number of worker processes the coordinator waits for (default: 2, env: BENCH_WORKERS)
+  --listen ADDR		address the coordinator listens on (default: :7000, env: BENCH_LISTEN)
+  --coordinator URL	coordinator to register with, e.g. http://10.0.0.5:7000 (env: BENCH_COORDINATOR)`)
+	}
+
+	cmd := "bench"
+	args := os.Args[1:]
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		cmd, args = args[0], args[1:]
+	}
+	switch cmd {
+	case "replay":
+		runReplay(args)
+		return
+	case "report":
+		runReport(args)
+		return
+	case "bench", "validate", "init", "coordinator", "worker":
+	default:
+		log.Printf("Unknown command: %s", cmd)
+		flag.Usage()
+		os.Exit(1)
 	}
 
 	var (
-		ip = flag.String("ip", "127.0.0.1", "")
+		target   = addTargetFlags(flag.CommandLine)
+		duration = flag.Duration("duration", getEnvDuration("BENCH_DURATION", 1*time.Minute), "")
+		workload = flag.Int("workload", getEnvInt("BENCH_WORKLOAD", 5), "")
+		mixStr   = flag.String("mix", getEnv("BENCH_MIX", "just=1,stalker=1,bakugai=1"), "")
+		output   = flag.String("output", getEnv("BENCH_OUTPUT", ""), "")
+		seed     = flag.Int64("seed", int64(getEnvInt("BENCH_SEED", 0)), "")
+
+		fixturePath     = flag.String("fixture", getEnv("BENCH_FIXTURE", ""), "")
+		generateFixture = flag.String("generate-fixture", "", "")
+
+		contentSampleRate  = flag.Float64("content-sample-rate", getEnvFloat("BENCH_CONTENT_SAMPLE_RATE", 5), "")
+		contentMaxFailures = flag.Int("content-max-failures", getEnvInt("BENCH_CONTENT_MAX_FAILURES", 10), "")
+
+		thinkTimeStr = flag.String("think-time", getEnv("BENCH_THINK_TIME", "0"), "")
+		arrivalRate  = flag.Float64("arrival-rate", getEnvFloat("BENCH_ARRIVAL_RATE", 0), "")
+		maxUsers     = flag.Int("max-users", getEnvInt("BENCH_MAX_USERS", 1000), "")
+
+		rampInterval     = flag.Duration("ramp-interval", getEnvDuration("BENCH_RAMP_INTERVAL", 0), "")
+		rampStep         = flag.Int("ramp-step", getEnvInt("BENCH_RAMP_STEP", 3), "")
+		maxWorkload      = flag.Int("max-workload", getEnvInt("BENCH_MAX_WORKLOAD", 30), "")
+		rampMaxErrorRate = flag.Float64("ramp-max-error-rate", getEnvFloat("BENCH_RAMP_MAX_ERROR_RATE", 1), "")
+		rampMaxLatency   = flag.Duration("ramp-max-latency", getEnvDuration("BENCH_RAMP_MAX_LATENCY", 500*time.Millisecond), "")
+
+		scoringPath   = flag.String("scoring", getEnv("BENCH_SCORING", ""), "")
+		scenariosPath = flag.String("scenarios", getEnv("BENCH_SCENARIOS", ""), "")
+		recordPath    = flag.String("record", getEnv("BENCH_RECORD", ""), "")
+
+		failErrorRate  = flag.Float64("fail-error-rate", getEnvFloat("BENCH_FAIL_ERROR_RATE", 50), "")
+		failErrorCount = flag.Int("fail-error-count", getEnvInt("BENCH_FAIL_ERROR_COUNT", 0), "")
+		failWindow     = flag.Duration("fail-window", getEnvDuration("BENCH_FAIL_WINDOW", 10*time.Second), "")
+
+		workers        = flag.Int("workers", getEnvInt("BENCH_WORKERS", 2), "")
+		listen         = flag.String("listen", getEnv("BENCH_LISTEN", ":7000"), "")
+		coordinatorURL = flag.String("coordinator", getEnv("BENCH_COORDINATOR", "http://127.0.0.1:7000"), "")
 	)
-	flag.Parse()
-	host = "http://" + *ip
+	flag.CommandLine.Parse(args)
+	if err := target.apply(); err != nil {
+		log.Printf("Invalid target: %v", err)
+		os.Exit(1)
+	}
+	log.Printf("Target: %v", target)
+
+	if *generateFixture != "" {
+		if err := generateFixtureFile(*generateFixture); err != nil {
+			log.Printf("Failed to generate fixture: %v", err)
+			os.Exit(1)
+		}
+		log.Printf("Wrote fixture to %s", *generateFixture)
+		return
+	}
+	if *fixturePath != "" {
+		f, err := loadFixture(*fixturePath)
+		if err != nil {
+			log.Printf("Failed to load fixture: %v", err)
+			os.Exit(1)
+		}
+		fixtureData = f
+		log.Printf("Using fixture %s (%d users, %d products)", *fixturePath, len(f.Users), len(f.Products))
+	}
+
+	switch cmd {
+	case "init":
+		runInit()
+		return
+	case "validate":
+		runValidate()
+		return
+	}
+
+	if *duration <= 0 {
+		log.Printf("Invalid --duration: %v", *duration)
+		os.Exit(1)
+	}
+	if *workload <= 0 {
+		log.Printf("Invalid --workload: %d", *workload)
+		os.Exit(1)
+	}
+	if *scenariosPath != "" {
+		s, err := loadScenarioFile(*scenariosPath)
+		if err != nil {
+			log.Printf("Failed to load scenarios: %v", err)
+			os.Exit(1)
+		}
+		scenarios = s
+		log.Printf("Using scenarios %s (%s)", *scenariosPath, strings.Join(scenarioNames(), ", "))
+	}
+	mix, err := parseMix(*mixStr)
+	if err != nil {
+		log.Printf("Invalid --mix: %v", err)
+		os.Exit(1)
+	}
+	think, err := parseThinkTime(*thinkTimeStr)
+	if err != nil {
+		log.Printf("Invalid --think-time: %v", err)
+		os.Exit(1)
+	}
+	defaultThinkTime = think
+	if *arrivalRate < 0 || (*arrivalRate > 0 && (*maxUsers <= 0 || *rampInterval > 0)) {
+		log.Printf("Invalid open model settings: --arrival-rate=%v, --max-users=%d (ramp-up is not available with --arrival-rate)",
+			*arrivalRate, *maxUsers)
+		os.Exit(1)
+	}
+	if *rampInterval <= 0 {
+		*maxWorkload = *workload
+	} else if *rampStep <= 0 || *maxWorkload < *workload {
+		log.Printf("Invalid ramp-up settings: --ramp-step=%d, --max-workload=%d", *rampStep, *maxWorkload)
+		os.Exit(1)
+	}
 
-	// Always use the maximum value for workload
-	workload := 5
-	log.Printf("Using maximum workload: %d", workload)
+	if *scoringPath != "" {
+		p, err := loadScoringPolicy(*scoringPath)
+		if err != nil {
+			log.Printf("Failed to load scoring policy: %v", err)
+			os.Exit(1)
+		}
+		scoring = p
+		log.Printf("Using scoring policy %s (%v)", *scoringPath, p)
+	}
 
-	startBenchmark(workload)
+	if *failErrorRate < 0 || *failErrorCount < 0 || *failWindow < time.Second {
+		log.Printf("Invalid fail-fast settings: --fail-error-rate=%v, --fail-error-count=%d, --fail-window=%v",
+			*failErrorRate, *failErrorCount, *failWindow)
+		os.Exit(1)
+	}
+	failGuard.maxErrorRate = *failErrorRate / 100
+	failGuard.maxErrors = *failErrorCount
+	failGuard.window = *failWindow
+
+	if *seed == 0 {
+		*seed = time.Now().UnixNano()
+	}
+	// The validator and the final checks draw from the global source
+	rand.Seed(*seed)
+
+	if *recordPath != "" {
+		t, err := newTraceRecorder(*recordPath)
+		if err != nil {
+			log.Printf("Failed to create trace: %v", err)
+			os.Exit(1)
+		}
+		tracer = t
+		log.Printf("Recording requests to %s", *recordPath)
+	}
+
+	content.sampleRate = *contentSampleRate / 100
+	content.maxFailures = *contentMaxFailures
+
+	cfg := benchConfig{
+		workload: *workload,
+		duration: *duration,
+		mix:      mix,
+		output:   *output,
+		seed:     *seed,
+
+		rampInterval:     *rampInterval,
+		rampStep:         *rampStep,
+		maxWorkload:      *maxWorkload,
+		rampMaxErrorRate: *rampMaxErrorRate / 100,
+		rampMaxLatency:   *rampMaxLatency,
+
+		arrivalRate: *arrivalRate,
+		maxUsers:    *maxUsers,
+	}
+	switch cmd {
+	case "coordinator":
+		if *workers <= 0 {
+			log.Printf("Invalid --workers: %d", *workers)
+			os.Exit(1)
+		}
+		runCoordinator(cfg, *listen, *workers)
+	case "worker":
+		runWorker(*coordinatorURL)
+	default:
+		startBenchmark(cfg)
+	}
 }
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"io"
+	"net"
+	"strings"
+	"syscall"
+)
+
+// Requests that got no HTTP response are reported with these statuses, like
+// statusInvalidContent, so that they are counted apart from real 5xx responses.
+const (
+	statusTimeout           = 990 // no response within the client timeout
+	statusConnectionRefused = 991
+	statusConnectionReset   = 992 // the connection was reset or closed before the response
+	statusTLSError          = 993
+	statusDNSError          = 994
+	statusConnectionError   = 995 // any other transport error
+)
+
+var statusLabels = map[int]string{
+	statusTimeout:           "timeout",
+	statusConnectionRefused: "connection_refused",
+	statusConnectionReset:   "connection_reset",
+	statusTLSError:          "tls_error",
+	statusDNSError:          "dns_error",
+	statusConnectionError:   "connection_error",
+	statusInvalidContent:    "invalid_content",
+}
+
+// isTransportError reports whether status stands for a request that got no HTTP response
+func isTransportError(status int) bool {
+	return status >= statusTimeout && status <= statusConnectionError
+}
+
+// errorStatus classifies an error returned by http.Client.Do
+func errorStatus(err error) int {
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return statusTimeout
+	}
+	var dnsErr *net.DNSError
+	if errors.As(err, &dnsErr) {
+		return statusDNSError
+	}
+	if errors.Is(err, syscall.ECONNREFUSED) {
+		return statusConnectionRefused
+	}
+	if errors.Is(err, syscall.ECONNRESET) || errors.Is(err, syscall.EPIPE) ||
+		errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return statusConnectionReset
+	}
+
+	var (
+		unknownAuthority x509.UnknownAuthorityError
+		hostname         x509.HostnameError
+		invalidCert      x509.CertificateInvalidError
+		recordHeader     tls.RecordHeaderError
+	)
+	if errors.As(err, &unknownAuthority) || errors.As(err, &hostname) ||
+		errors.As(err, &invalidCert) || errors.As(err, &recordHeader) ||
+		strings.Contains(err.Error(), "tls: ") {
+		return statusTLSError
+	}
+	return statusConnectionError
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"context"
+	"crypto/x509"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"syscall"
+	"testing"
+)
+
+// doError wraps err the way http.Client.Do returns it
+func doError(err error) error {
+	return &url.Error{Op: "Get", URL: "http://127.0.0.1/", Err: err}
+}
+
+func TestErrorStatus(t *testing.T) {
+	for _, tt := range []struct {
+		name string
+		err  error
+		want int
+	}{
+		{"deadline", doError(context.DeadlineExceeded), statusTimeout},
+		{"dns", doError(&net.OpError{Op: "dial", Err: &net.DNSError{Err: "no such host", Name: "example.invalid"}}), statusDNSError},
+		{"refused", doError(&net.OpError{Op: "dial", Err: os.NewSyscallError("connect", syscall.ECONNREFUSED)}), statusConnectionRefused},
+		{"reset", doError(&net.OpError{Op: "read", Err: os.NewSyscallError("read", syscall.ECONNRESET)}), statusConnectionReset},
+		{"eof", doError(io.EOF), statusConnectionReset},
+		{"unexpected eof", doError(io.ErrUnexpectedEOF), statusConnectionReset},
+		{"unknown authority", doError(x509.UnknownAuthorityError{}), statusTLSError},
+		{"tls message", doError(errors.New("remote error: tls: handshake failure")), statusTLSError},
+		{"other", doError(errors.New("malformed HTTP response")), statusConnectionError},
+	} {
+		if got := errorStatus(tt.err); got != tt.want {
+			t.Errorf("%s: errorStatus(%v) = %d, want %d", tt.name, tt.err, got, tt.want)
+		}
+	}
+}
+
+func TestErrorStatusRefused(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := l.Addr().String()
+	l.Close()
+
+	resp, err := http.Get("http://" + addr + "/")
+	if err == nil {
+		resp.Body.Close()
+		t.Skip("something is listening on the closed port")
+	}
+	if got := errorStatus(err); got != statusConnectionRefused {
+		t.Errorf("errorStatus(%v) = %d, want %d", err, got, statusConnectionRefused)
+	}
+}
+
+func TestIsTransportError(t *testing.T) {
+	for status := range statusLabels {
+		if got, want := isTransportError(status), status != statusInvalidContent; got != want {
+			t.Errorf("isTransportError(%d) = %v, want %v", status, got, want)
+		}
+	}
+	for _, status := range []int{statusCanceled, 200, 404, 500} {
+		if isTransportError(status) {
+			t.Errorf("isTransportError(%d) = true, want false", status)
+		}
+	}
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"strings"
+	"time"
+)
+
+// An exponential think time is cut off at this many times its mean
+const maxThinkTimeFactor = 10
+
+// thinkTime is the pause of a virtual user after a step, written as
+//
+//	500ms                      always 500ms
+//	uniform:200ms-800ms        anywhere from 200ms to 800ms
+//	exponential:500ms          exponentially distributed with a mean of 500ms
+type thinkTime struct {
+	kind string // "fixed", "uniform" or "exponential"
+	min  time.Duration
+	max  time.Duration // uniform only
+}
+
+// Set by --think-time; used after every step without a think time of its own
+var defaultThinkTime thinkTime
+
+func parseThinkTime(s string) (thinkTime, error) {
+	t := thinkTime{kind: "fixed"}
+	spec := s
+	if i := strings.Index(s, ":"); i >= 0 {
+		t.kind, spec = s[:i], s[i+1:]
+	}
+	var err error
+	switch t.kind {
+	case "fixed", "exponential":
+		t.min, err = time.ParseDuration(spec)
+	case "uniform":
+		bounds := strings.SplitN(spec, "-", 2)
+		if len(bounds) != 2 {
+			return t, fmt.Errorf("invalid think time %q (want uniform:MIN-MAX)", s)
+		}
+		if t.min, err = time.ParseDuration(bounds[0]); err == nil {
+			t.max, err = time.ParseDuration(bounds[1])
+		}
+		if err == nil && t.max < t.min {
+			return t, fmt.Errorf("invalid think time %q: MAX is less than MIN", s)
+		}
+	default:
+		return t, fmt.Errorf("invalid think time %q (want DURATION, uniform:MIN-MAX or exponential:MEAN)", s)
+	}
+	if err != nil || t.min < 0 {
+		return t, fmt.Errorf("invalid think time %q", s)
+	}
+	return t, nil
+}
+
+// sample draws one pause; r is only used when the pause is random
+func (t thinkTime) sample(r *rand.Rand) time.Duration {
+	switch t.kind {
+	case "uniform":
+		return t.min + time.Duration(r.Int63n(int64(t.max-t.min)+1))
+	case "exponential":
+		d := time.Duration(r.ExpFloat64() * float64(t.min))
+		if d > maxThinkTimeFactor*t.min {
+			d = maxThinkTimeFactor * t.min
+		}
+		return d
+	default:
+		return t.min
+	}
+}
+
+func (t thinkTime) String() string {
+	switch t.kind {
+	case "uniform":
+		return fmt.Sprintf("uniform:%v-%v", t.min, t.max)
+	case "exponential":
+		return fmt.Sprintf("exponential:%v", t.min)
+	default:
+		return t.min.String()
+	}
+}
+
+func (t *thinkTime) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return fmt.Errorf("think time %s: want a string such as \"500ms\" or \"exponential:1s\"", b)
+	}
+	v, err := parseThinkTime(s)
+	if err != nil {
+		return err
+	}
+	*t = v
+	return nil
+}
+
+func (t thinkTime) MarshalJSON() ([]byte, error) {
+	return json.Marshal(t.String())
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"encoding/json"
+	"math/rand"
+	"testing"
+	"time"
+)
+
+func TestParseThinkTime(t *testing.T) {
+	for _, tt := range []struct {
+		in   string
+		want thinkTime
+	}{
+		{"0", thinkTime{kind: "fixed"}},
+		{"500ms", thinkTime{kind: "fixed", min: 500 * time.Millisecond}},
+		{"uniform:200ms-800ms", thinkTime{kind: "uniform", min: 200 * time.Millisecond, max: 800 * time.Millisecond}},
+		{"uniform:1s-1s", thinkTime{kind: "uniform", min: time.Second, max: time.Second}},
+		{"exponential:1.5s", thinkTime{kind: "exponential", min: 1500 * time.Millisecond}},
+	} {
+		got, err := parseThinkTime(tt.in)
+		if err != nil {
+			t.Errorf("parseThinkTime(%q): %v", tt.in, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("parseThinkTime(%q) = %+v, want %+v", tt.in, got, tt.want)
+		}
+		if back, err := parseThinkTime(got.String()); err != nil || back != got {
+			t.Errorf("%q: String() = %q does not parse back", tt.in, got.String())
+		}
+	}
+}
+
+func TestParseThinkTimeErrors(t *testing.T) {
+	for _, in := range []string{
+		"",
+		"500",
+		"-1s",
+		"gaussian:1s",
+		"uniform:1s",
+		"uniform:2s-1s",
+		"uniform:-1s-1s",
+		"exponential:",
+	} {
+		if got, err := parseThinkTime(in); err == nil {
+			t.Errorf("parseThinkTime(%q) = %+v, want an error", in, got)
+		}
+	}
+}
+
+func TestThinkTimeSample(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	fixed := thinkTime{kind: "fixed", min: 300 * time.Millisecond}
+	uniform := thinkTime{kind: "uniform", min: 200 * time.Millisecond, max: 800 * time.Millisecond}
+	exponential := thinkTime{kind: "exponential", min: 100 * time.Millisecond}
+	var sum time.Duration
+	const n = 10000
+	for i := 0; i < n; i++ {
+		if d := fixed.sample(r); d != fixed.min {
+			t.Fatalf("fixed: %v, want %v", d, fixed.min)
+		}
+		if d := uniform.sample(r); d < uniform.min || d > uniform.max {
+			t.Fatalf("uniform: %v is out of [%v, %v]", d, uniform.min, uniform.max)
+		}
+		d := exponential.sample(r)
+		if d < 0 || d > maxThinkTimeFactor*exponential.min {
+			t.Fatalf("exponential: %v is out of [0, %v]", d, maxThinkTimeFactor*exponential.min)
+		}
+		sum += d
+	}
+	if mean := sum / n; mean < 90*time.Millisecond || mean > 110*time.Millisecond {
+		t.Errorf("exponential: mean %v, want about %v", mean, exponential.min)
+	}
+}
+
+func TestThinkTimeJSON(t *testing.T) {
+	var step scenarioStep
+	if err := json.Unmarshal([]byte(`{"action": "index", "think": "uniform:1s-2s"}`), &step); err != nil {
+		t.Fatal(err)
+	}
+	if want := (thinkTime{kind: "uniform", min: time.Second, max: 2 * time.Second}); step.Think == nil || *step.Think != want {
+		t.Errorf("think = %+v, want %+v", step.Think, want)
+	}
+	for _, in := range []string{`{"think": 500}`, `{"think": "soon"}`} {
+		if err := json.Unmarshal([]byte(in), &step); err == nil {
+			t.Errorf("%s: want an error", in)
+		}
+	}
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"log"
+	"net/url"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Log at most this many requests whose status differs from the trace
+const maxReportedMismatches = 20
+
+// runReplay implements `benchmark replay [options] TRACE`
+func runReplay(args []string) {
+	fs := flag.NewFlagSet("replay", flag.ExitOnError)
+	fs.Usage = flag.Usage
+	var (
+		target     = addTargetFlags(fs)
+		speed      = fs.Float64("speed", 1, "")
+		initialize = fs.Bool("initialize", true, "")
+	)
+	fs.Parse(args)
+	if fs.NArg() != 1 || *speed < 0 {
+		flag.Usage()
+		os.Exit(1)
+	}
+	if err := target.apply(); err != nil {
+		log.Printf("Invalid target: %v", err)
+		os.Exit(1)
+	}
+
+	entries, err := loadTrace(fs.Arg(0))
+	if err != nil {
+		log.Printf("Failed to load trace: %v", err)
+		os.Exit(1)
+	}
+	if *initialize {
+		getInitialize()
+	}
+	log.Printf("Replaying %d requests from %s at %gx speed", len(entries), fs.Arg(0), *speed)
+	replayTrace(entries, *speed)
+}
+
+// replayTrace re-issues the requests of a trace and returns how many got a
+// different status. Each recorded agent sends its requests in the recorded
+// order, starting a new session where it did; speed scales the original
+// timing (2 = twice as fast) and 0 sends them without waiting.
+func replayTrace(entries []traceEntry, speed float64) int {
+	byAgent := map[int][]traceEntry{}
+	for _, e := range entries {
+		byAgent[e.Agent] = append(byAgent[e.Agent], e)
+	}
+	ids := make([]int, 0, len(byAgent))
+	for id := range byAgent {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+
+	var (
+		mu         sync.Mutex
+		mismatches int
+	)
+	stats.reset()
+	wg := new(sync.WaitGroup)
+	start := time.Now()
+	for _, id := range ids {
+		wg.Add(1)
+		go func(id int, entries []traceEntry) {
+			defer wg.Done()
+			a := newAgent(0)
+			a.id = id
+			session := -1
+			for _, e := range entries {
+				if e.Session != session {
+					a.reset()
+					session = e.Session
+				}
+				if speed > 0 {
+					at := time.Duration(e.OffsetMs / speed * float64(time.Millisecond))
+					if wait := at - time.Since(start); wait > 0 {
+						time.Sleep(wait)
+					}
+				}
+				params, _ := url.ParseQuery(e.Form)
+				r := httpRequest(context.Background(), a, e.Method, e.Path, params)
+				if r.status == e.Status {
+					continue
+				}
+				mu.Lock()
+				mismatches++
+				if mismatches <= maxReportedMismatches {
+					log.Printf("Agent %d: %s %s: recorded %s, replayed %s",
+						id, e.Method, e.Path, statusLabel(e.Status), statusLabel(r.status))
+				}
+				mu.Unlock()
+			}
+		}(id, byAgent[id])
+	}
+	wg.Wait()
+
+	log.Printf("Replay finished in %v (%d agents)", time.Since(start), len(ids))
+	showEndpointStats()
+	log.Printf("%d of %d requests got a different status than recorded", mismatches, len(entries))
+	return mismatches
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+)
+
+// sessionServer answers GET /users/1 with 200 only to a session that logged in
+func sessionServer() *httptest.Server {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/login", func(w http.ResponseWriter, r *http.Request) {
+		http.SetCookie(w, &http.Cookie{Name: "session", Value: "1", Path: "/"})
+	})
+	mux.HandleFunc("/users/1", func(w http.ResponseWriter, r *http.Request) {
+		if _, err := r.Cookie("session"); err != nil {
+			http.Error(w, "log in first", http.StatusForbidden)
+		}
+	})
+	return httptest.NewServer(mux)
+}
+
+func TestRecordAndReplaySessions(t *testing.T) {
+	server := sessionServer()
+	defer server.Close()
+	var err error
+	if balancer, err = newTargetBalancer(balanceRoundRobin, weightedList{{server.URL, 1}}); err != nil {
+		t.Fatal(err)
+	}
+
+	path := writeTempFile(t, "")
+	defer os.Remove(path)
+	if tracer, err = newTraceRecorder(path); err != nil {
+		t.Fatal(err)
+	}
+	defer func() { tracer = nil }()
+	tracer.begin(time.Now())
+
+	// Logged in, then a new session that is not
+	a := newAgent(1)
+	a.id = 1
+	a.reset()
+	ctx := context.Background()
+	if r := httpRequest(ctx, a, "POST", "/login", nil); r.status != http.StatusOK {
+		t.Fatalf("POST /login: status %d", r.status)
+	}
+	if r := httpRequest(ctx, a, "GET", "/users/1", nil); r.status != http.StatusOK {
+		t.Fatalf("GET /users/1 after login: status %d, want 200", r.status)
+	}
+	a.reset()
+	if r := httpRequest(ctx, a, "GET", "/users/1", nil); r.status != http.StatusForbidden {
+		t.Fatalf("GET /users/1 in a new session: status %d, want 403", r.status)
+	}
+	if err := tracer.close(); err != nil {
+		t.Fatal(err)
+	}
+
+	entries, err := loadTrace(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 3 {
+		t.Fatalf("recorded %d requests, want 3", len(entries))
+	}
+	if entries[0].Session != entries[1].Session || entries[1].Session == entries[2].Session {
+		t.Errorf("sessions %d, %d, %d: want the last request in a new session",
+			entries[0].Session, entries[1].Session, entries[2].Session)
+	}
+
+	tracer = nil
+	if n := replayTrace(entries, 0); n != 0 {
+		t.Errorf("replay: %d requests got a different status than recorded", n)
+	}
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"sort"
+	"strings"
+)
+
+// runReport implements `benchmark report RESULT`
+func runReport(args []string) {
+	if len(args) != 1 {
+		flag.Usage()
+		os.Exit(1)
+	}
+	r, err := readResult(args[0])
+	if err != nil {
+		log.Printf("Failed to read result: %v", err)
+		os.Exit(1)
+	}
+	fmt.Print(formatReport(r))
+}
+
+func readResult(path string) (benchResult, error) {
+	var r benchResult
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return r, err
+	}
+	if err := json.Unmarshal(b, &r); err != nil {
+		return r, fmt.Errorf("%s: %v", path, err)
+	}
+	return r, nil
+}
+
+// formatReport renders a saved result the way the benchmark logs it at the end of a run
+func formatReport(r benchResult) string {
+	var b strings.Builder
+	verdict := "PASS"
+	if !r.Passed {
+		verdict = "FAIL"
+	}
+	fmt.Fprintf(&b, "Result:   %s\n", verdict)
+	fmt.Fprintf(&b, "Score:    %d\n", r.Score)
+	fmt.Fprintf(&b, "Target:   %s\n", r.Target)
+	fmt.Fprintf(&b, "Started:  %s (%s, version %s)\n", r.StartTime.Format("2006-01-02 15:04:05"), r.Duration, r.Version)
+	if r.ArrivalRate > 0 {
+		fmt.Fprintf(&b, "Users:    %g/s arriving, %d arrived, %d turned away (peak %d at once), mix %s, seed %d\n",
+			r.ArrivalRate, r.Arrivals, r.DroppedArrivals, r.PeakWorkers, r.Mix, r.Seed)
+	} else {
+		fmt.Fprintf(&b, "Workers:  %d (peak %d), mix %s, seed %d\n", r.Workload, r.PeakWorkers, r.Mix, r.Seed)
+	}
+	if r.ThinkTime != "" && r.ThinkTime != "0s" {
+		fmt.Fprintf(&b, "Think:    %s\n", r.ThinkTime)
+	}
+	if r.AbortReason != "" {
+		fmt.Fprintf(&b, "Aborted:  %s\n", r.AbortReason)
+	}
+
+	if !r.Validation.Passed {
+		fmt.Fprintf(&b, "\nValidation failed: %d check(s)\n", len(r.Validation.Failures))
+		for _, f := range r.Validation.Failures {
+			fmt.Fprintf(&b, "  %s\n", f)
+		}
+		return b.String()
+	}
+
+	fmt.Fprintf(&b, "\nChecks:\n")
+	for _, c := range []struct {
+		name string
+		r    checkResult
+	}{
+		{"content", r.ContentChecks},
+		{"purchase", r.PurchaseChecks},
+		{"comment", r.CommentChecks},
+	} {
+		fmt.Fprintf(&b, "  %-10s %d checked, %d failed\n", c.name, c.r.Checked, c.r.Failed)
+		for _, f := range c.r.Failures {
+			fmt.Fprintf(&b, "    %s\n", f)
+		}
+	}
+
+	if len(r.ScenarioScores) > 0 {
+		fmt.Fprintf(&b, "\nScenario scores:\n")
+		names := make([]string, 0, len(r.ScenarioScores))
+		for name := range r.ScenarioScores {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			fmt.Fprintf(&b, "  %-10s %.1f\n", name, r.ScenarioScores[name])
+		}
+	}
+
+	if len(r.Endpoints) > 0 {
+		fmt.Fprintf(&b, "\nEndpoint statistics:\n")
+		fmt.Fprintf(&b, "  %-28s %8s %7s %9s %9s %9s %9s\n", "ENDPOINT", "COUNT", "ERRORS", "P50", "P90", "P99", "MAX")
+		for _, e := range r.Endpoints {
+			writeStatsRow(&b, e.Endpoint, e.statsResult)
+		}
+	}
+	if len(r.Targets) > 1 {
+		fmt.Fprintf(&b, "\nTarget statistics:\n")
+		fmt.Fprintf(&b, "  %-28s %8s %7s %9s %9s %9s %9s\n", "TARGET", "COUNT", "ERRORS", "P50", "P90", "P99", "MAX")
+		for _, t := range r.Targets {
+			writeStatsRow(&b, t.Target, t.statsResult)
+		}
+	}
+
+	if len(r.Errors) > 0 {
+		fmt.Fprintf(&b, "\nErrors:\n")
+		labels := make([]string, 0, len(r.Errors))
+		for label := range r.Errors {
+			labels = append(labels, label)
+		}
+		sort.Strings(labels)
+		for _, label := range labels {
+			fmt.Fprintf(&b, "  %-28s %8d\n", label, r.Errors[label])
+		}
+	}
+	return b.String()
+}
+
+func writeStatsRow(b *strings.Builder, name string, s statsResult) {
+	fmt.Fprintf(b, "  %-28s %8d %7d %8.1fms %8.1fms %8.1fms %8.1fms\n",
+		name, s.Requests, s.Errors, s.P50Ms, s.P90Ms, s.P99Ms, s.MaxMs)
+}
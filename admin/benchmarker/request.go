@@ -1,7 +1,13 @@
 package main
 
 import (
+	"bytes"
+	"context"
+	"io"
+	"io/ioutil"
 	"log"
+	"math/rand"
+	"net"
 	"net/http"
 	"net/http/cookiejar"
 	"net/url"
@@ -10,13 +16,74 @@ import (
 	"strings"
 	"time"
 
+	"github.com/PuerkitoBio/goquery"
 	_ "github.com/go-sql-driver/mysql"
 )
 
+// statusCanceled is returned for requests cut off by the end of the benchmark; they are not scored
+const statusCanceled = 0
+
+// response is the outcome of a single request, as seen by the scoring policy
+type response struct {
+	endpoint string // route template, see endpointName
+	status   int    // HTTP status, or one of the statuses in outcome.go
+	location string // path of the last request sent, after following redirects
+}
+
+// Shared by every agent so that connections to the target are kept alive and reused
+var transport = &http.Transport{
+	Proxy: http.ProxyFromEnvironment,
+	DialContext: (&net.Dialer{
+		Timeout:   5 * time.Second,
+		KeepAlive: 30 * time.Second,
+	}).DialContext,
+	MaxIdleConns:        1024,
+	MaxIdleConnsPerHost: 1024,
+	IdleConnTimeout:     90 * time.Second,
+}
+
+// Used by the validators and checkers, which request pages without a session
+var anonymousClient = &http.Client{Transport: targetTransport, Timeout: 30 * time.Second}
+
+// agent is a virtual user: an HTTP client with its own cookie jar and random source
+type agent struct {
+	id      int    // 1.. for scenario workers, 0 for the validator and other one-off agents
+	target  string // base URL the requests go to
+	placed  bool   // target was picked by the balancer
+	session int    // sessions started so far, see reset
+	client  *http.Client
+	rand    *rand.Rand // used by this agent only, so its requests follow from the seed
+}
+
+func newAgent(seed int64) *agent {
+	a := &agent{
+		target: host,
+		client: &http.Client{Transport: targetTransport, Timeout: 30 * time.Second},
+		rand:   rand.New(rand.NewSource(seed)),
+	}
+	a.reset()
+	return a
+}
+
+// reset drops the cookies, starting a new session. Scenario workers get their
+// target with their first session and may move to another one with each new
+// session, see targetBalancer.
+func (a *agent) reset() {
+	jar, _ := cookiejar.New(nil)
+	a.client.Jar = jar
+	a.session++
+	if a.id > 0 && (!a.placed || balancer.perSession()) {
+		a.target = balancer.pick()
+		a.placed = true
+	}
+}
+
 func getInitialize() {
 	log.Print("Start GET /initialize")
 	startTime := time.Now()
-	httpRequest("GET", "/initialize", nil, nil)
+	a := newAgent(rand.Int63())
+	a.client.Timeout = 12 * time.Minute
+	httpRequest(context.Background(), a, "GET", "/initialize", nil)
 	elapsed := time.Since(startTime)
 	if elapsed > 10*time.Minute {
 		log.Printf("Timeover at GET /initialize (took %v)", elapsed)
@@ -25,88 +92,161 @@ func getInitialize() {
 	log.Printf("GET /initialize completed in %v", elapsed)
 }
 
-func getIndex(c []*http.Cookie, page int) (int, []*http.Cookie) {
-	return httpRequest("GET", "/?page="+strconv.Itoa(page), nil, c)
+func getIndex(ctx context.Context, a *agent, page int) response {
+	path := "/?page=" + strconv.Itoa(page)
+	check := content.checker(a.rand, func(doc *goquery.Document) []validationFailure {
+		return checkIndexDocument(doc, "GET "+path, page)
+	})
+	return httpRequestWithCheck(ctx, a, "GET", path, nil, check)
 }
 
-func getImage(c []*http.Cookie, id int) (int, []*http.Cookie) {
-	return httpRequest("GET", "/images/image"+strconv.Itoa(id)+".jpg", nil, c)
+func getImage(ctx context.Context, a *agent, id int) response {
+	return httpRequest(ctx, a, "GET", "/images/image"+strconv.Itoa(id)+".jpg", nil)
 }
 
-func getProduct(c []*http.Cookie, id int) (int, []*http.Cookie) {
+func getProduct(ctx context.Context, a *agent, id int) response {
 	if id == 0 {
-		id = getRand(1, 10000)
+		id = getRand(a.rand, 1, 10000)
 	}
-	return httpRequest("GET", "/products/"+strconv.Itoa(id), nil, c)
+	path := "/products/" + strconv.Itoa(id)
+	check := content.checker(a.rand, func(doc *goquery.Document) []validationFailure {
+		return checkProductDocument(doc, "GET "+path, id)
+	})
+	return httpRequestWithCheck(ctx, a, "GET", path, nil, check)
 }
 
-func getUserPage(c []*http.Cookie, id int) (int, []*http.Cookie) {
+func getUserPage(ctx context.Context, a *agent, id int) response {
 	if id == 0 {
-		id = getRand(1, 5000)
+		id = getRand(a.rand, 1, 5000)
 	}
-	return httpRequest("GET", "/users/"+strconv.Itoa(id), nil, c)
+	path := "/users/" + strconv.Itoa(id)
+	check := content.checker(a.rand, func(doc *goquery.Document) []validationFailure {
+		return checkUserDocument(doc, "GET "+path)
+	})
+	return httpRequestWithCheck(ctx, a, "GET", path, nil, check)
 }
 
-func postLogin(c []*http.Cookie, email string, password string) (int, []*http.Cookie) {
+func postLogin(ctx context.Context, a *agent, email string, password string) response {
 	v := url.Values{}
 	v.Add("email", email)
 	v.Add("password", password)
-	return httpRequest("POST", "/login", v, c)
+	return httpRequest(ctx, a, "POST", "/login", v)
 }
 
-func getLogout(c []*http.Cookie) (int, []*http.Cookie) {
-	return httpRequest("GET", "/logout", nil, c)
+func getLogout(ctx context.Context, a *agent) response {
+	return httpRequest(ctx, a, "GET", "/logout", nil)
 }
 
-func buyProduct(c []*http.Cookie, productID int) (int, []*http.Cookie) {
+func buyProduct(ctx context.Context, a *agent, userID int, productID int) response {
 	if productID == 0 {
-		productID = getRand(1, 10000)
+		productID = getRand(a.rand, 1, 10000)
 	}
 
-	return httpRequest("POST", "/products/buy/"+strconv.Itoa(productID), nil, c)
+	// Every purchase goes into the ledger so the user page can be checked later
+	ledger.begin(userID)
+	resp := httpRequest(ctx, a, "POST", "/products/buy/"+strconv.Itoa(productID), nil)
+	ledger.finish(userID, productID, redirectedToUser(resp, userID))
+	return resp
 }
 
-func buyProductForValidation(c []*http.Cookie, userId int, productID int) (int, []*http.Cookie) {
-	if productID == 0 {
-		productID = getRand(1, 10000)
-	}
+// redirectedToUser reports whether a purchase or comment ended on the page of
+// userID. Without a session the app answers with the login page and a 200.
+func redirectedToUser(r response, userID int) bool {
+	return r.status == http.StatusOK && strings.HasSuffix(r.location, "/users/"+strconv.Itoa(userID))
+}
 
+func buyProductForValidation(ctx context.Context, a *agent, userId int, productID int) response {
 	// Execute purchase processing via the application endpoint
-	return httpRequest("POST", "/products/buy/"+strconv.Itoa(productID), nil, c)
+	return buyProduct(ctx, a, userId, productID)
 }
 
-func sendComment(c []*http.Cookie, productID int) (int, []*http.Cookie) {
+func sendComment(ctx context.Context, a *agent, userID int, productID int) response {
 	if productID == 0 {
-		productID = getRand(1, 10000)
+		productID = share.product(a.rand)
 	}
 	v := url.Values{}
 	opt := []string{"爆買いしてよかった。", "二度と買わない。", "友達にも勧めます。"}
-	v.Add("content", strings.Repeat("この商品は"+choice(opt), 5))
-	return httpRequest("POST", "/comments/"+strconv.Itoa(productID), v, c)
+	comment := postedComment{userID: userID, content: strings.Repeat("この商品は"+choice(a.rand, opt), 5)}
+	v.Add("content", comment.content)
+
+	// Every comment goes into the ledger so the index page can be checked later
+	comments.begin(productID)
+	resp := httpRequest(ctx, a, "POST", "/comments/"+strconv.Itoa(productID), v)
+	comments.finish(productID, comment, redirectedToUser(resp, userID))
+	return resp
+}
+
+func httpRequest(ctx context.Context, a *agent, method string, path string, params url.Values) response {
+	return httpRequestWithCheck(ctx, a, method, path, params, nil)
 }
 
-func httpRequest(method string, path string, params url.Values, cookies []*http.Cookie) (int, []*http.Cookie) {
-	req, _ := http.NewRequest(method, host+path, strings.NewReader(params.Encode()))
+// httpRequestWithCheck parses a 200 response and runs check on it when check is not nil.
+// A response failing the check is reported as statusInvalidContent.
+func httpRequestWithCheck(ctx context.Context, a *agent, method string, path string, params url.Values, check func(*goquery.Document) []validationFailure) response {
+	req, _ := http.NewRequest(method, a.target+path, strings.NewReader(params.Encode()))
+	req = req.WithContext(ctx)
 	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
-	jar, _ := cookiejar.New(nil)
-	CookieURL, _ := url.Parse(host + path)
-	jar.SetCookies(CookieURL, cookies)
-	
-	// Set a longer timeout for /initialize
-	timeout := 30 * time.Second
-	if path == "/initialize" {
-		timeout = 12 * time.Minute
+
+	r := response{endpoint: endpointName(method, path)}
+	startTime := time.Now()
+	resp, err := a.client.Do(req)
+	if ctx.Err() != nil {
+		// The benchmark is over; whatever happened is not counted
+		if err == nil {
+			resp.Body.Close()
+		}
+		r.status = statusCanceled
+		return r
 	}
-	client := http.Client{
-		Jar:     jar,
-		Timeout: timeout,
+	if err != nil {
+		r.status = errorStatus(err)
+		recordRequest(a.target, method, path, r.status, time.Since(startTime))
+		tracer.record(a, method, path, params, startTime, r.status, time.Since(startTime))
+		return r
 	}
+	defer resp.Body.Close()
 
-	resp, err := client.Do(req)
+	// The response is only complete once the body has been read: a timeout or
+	// a reset while reading it is a transport error, not the status received
+	parse := check != nil && resp.StatusCode == http.StatusOK
+	var body []byte
+	if parse {
+		body, err = ioutil.ReadAll(resp.Body)
+	} else {
+		// Drain the body so the connection goes back to the pool
+		_, err = io.Copy(ioutil.Discard, resp.Body)
+	}
+	elapsed := time.Since(startTime)
+	if ctx.Err() != nil {
+		// The body was cut off by the end of the benchmark
+		r.status = statusCanceled
+		return r
+	}
 	if err != nil {
-		return 500, cookies
+		r.status = errorStatus(err)
+		recordRequest(a.target, method, path, r.status, elapsed)
+		tracer.record(a, method, path, params, startTime, r.status, elapsed)
+		return r
 	}
-	defer resp.Body.Close()
 
-	return resp.StatusCode, jar.Cookies(CookieURL)
+	status := resp.StatusCode
+	if parse {
+		var failures []validationFailure
+		doc, err := goquery.NewDocumentFromReader(bytes.NewReader(body))
+		if err != nil {
+			failures = []validationFailure{{Endpoint: method + " " + path, Check: "parse HTML", Expected: "valid HTML", Actual: err.Error()}}
+		} else {
+			failures = check(doc)
+		}
+		content.add(failures)
+		if len(failures) > 0 {
+			status = statusInvalidContent
+		}
+	}
+	recordRequest(a.target, method, path, status, elapsed)
+	tracer.record(a, method, path, params, startTime, status, elapsed)
+
+	r.status = status
+	r.location = resp.Request.URL.Path
+	return r
 }
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+// testAgent sends its requests to url, without a target balancer
+func testAgent(url string) *agent {
+	a := newAgent(1)
+	a.target = url
+	return a
+}
+
+func TestHTTPRequestBodyErrors(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/ok", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("<html><body>ok</body></html>"))
+	})
+	// The headers promise a longer body than the one sent before the connection is closed
+	mux.HandleFunc("/cut", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Length", "1000")
+		w.Write([]byte("<html>"))
+		conn, _, err := w.(http.Hijacker).Hijack()
+		if err == nil {
+			conn.Close()
+		}
+	})
+	// The headers come at once, the body never does
+	stall := make(chan struct{})
+	mux.HandleFunc("/stall", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("<html>"))
+		w.(http.Flusher).Flush()
+		select {
+		case <-stall:
+		case <-r.Context().Done():
+		}
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+	defer close(stall)
+
+	check := func(*goquery.Document) []validationFailure { return nil }
+	for _, tt := range []struct {
+		path  string
+		check func(*goquery.Document) []validationFailure
+		want  int
+	}{
+		{"/ok", nil, http.StatusOK},
+		{"/ok", check, http.StatusOK},
+		{"/cut", nil, statusConnectionReset},
+		{"/cut", check, statusConnectionReset},
+		{"/stall", nil, statusTimeout},
+		{"/stall", check, statusTimeout},
+	} {
+		a := testAgent(server.URL)
+		a.client.Timeout = 200 * time.Millisecond
+		r := httpRequestWithCheck(context.Background(), a, "GET", tt.path, nil, tt.check)
+		if r.status != tt.want {
+			t.Errorf("GET %s (check: %v): status %d, want %d", tt.path, tt.check != nil, r.status, tt.want)
+		}
+	}
+}
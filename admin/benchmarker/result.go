@@ -0,0 +1,214 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"log"
+	"math"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Overridden at build time: go build -ldflags "-X main.version=v1.2.3"
+var version = "dev"
+
+type benchResult struct {
+	Version     string    `json:"version"`
+	Target      string    `json:"target"` // every target, with the weights and balance when more than one
+	StartTime   time.Time `json:"start_time"`
+	EndTime     time.Time `json:"end_time"`
+	Duration    string    `json:"duration"`
+	Workload    int       `json:"workload"`
+	PeakWorkers int       `json:"peak_workers"`
+	Mix         string    `json:"mix"`
+	Seed        int64     `json:"seed"`
+	ThinkTime   string    `json:"think_time"`
+
+	// Open model only
+	ArrivalRate     float64 `json:"arrival_rate,omitempty"`
+	Arrivals        int     `json:"arrivals,omitempty"`
+	DroppedArrivals int     `json:"dropped_arrivals,omitempty"`
+
+	Passed         bool               `json:"passed"`
+	AbortReason    string             `json:"abort_reason,omitempty"`
+	Validation     validationResult   `json:"validation"`
+	ContentChecks  checkResult        `json:"content_checks"`
+	PurchaseChecks checkResult        `json:"purchase_checks"`
+	CommentChecks  checkResult        `json:"comment_checks"`
+	Score          int                `json:"score"`
+	ScenarioScores map[string]float64 `json:"scenario_scores"`
+	Endpoints      []endpointResult   `json:"endpoints"`
+	Targets        []targetResult     `json:"targets"`
+	Errors         map[string]int     `json:"errors"`
+}
+
+type validationResult struct {
+	Passed   bool                `json:"passed"`
+	Failures []validationFailure `json:"failures"`
+}
+
+type checkResult struct {
+	Checked  int                 `json:"checked"`
+	Failed   int                 `json:"failed"`
+	Failures []validationFailure `json:"failures"`
+}
+
+type endpointResult struct {
+	Endpoint string `json:"endpoint"`
+	statsResult
+}
+
+type targetResult struct {
+	Target string `json:"target"`
+	statsResult
+}
+
+type statsResult struct {
+	Requests int     `json:"requests"`
+	Errors   int     `json:"errors"`
+	P50Ms    float64 `json:"p50_ms"`
+	P90Ms    float64 `json:"p90_ms"`
+	P99Ms    float64 `json:"p99_ms"`
+	MaxMs    float64 `json:"max_ms"`
+}
+
+// newResult fills in the run metadata; scores and statistics are added when the run ends
+func newResult(cfg benchConfig) benchResult {
+	return benchResult{
+		Version:        version,
+		Target:         balancer.String(),
+		StartTime:      time.Now(),
+		Duration:       cfg.duration.String(),
+		Workload:       cfg.workload,
+		Mix:            cfg.mix.String(),
+		Seed:           cfg.seed,
+		ThinkTime:      defaultThinkTime.String(),
+		ArrivalRate:    cfg.arrivalRate,
+		ContentChecks:  checkResult{Failures: []validationFailure{}},
+		PurchaseChecks: checkResult{Failures: []validationFailure{}},
+		CommentChecks:  checkResult{Failures: []validationFailure{}},
+		ScenarioScores: map[string]float64{},
+		Endpoints:      []endpointResult{},
+		Targets:        []targetResult{},
+		Errors:         map[string]int{},
+	}
+}
+
+// loadReport is what a load phase produced. In distributed mode every
+// worker process sends one to the coordinator, which merges them.
+type loadReport struct {
+	Index          int                `json:"index"`
+	ScenarioScores map[string]float64 `json:"scenario_scores"`
+	PeakWorkers    int                `json:"peak_workers"`
+	Arrivals       int                `json:"arrivals"`
+	Dropped        int                `json:"dropped"`
+	AbortReason    string             `json:"abort_reason"`
+	ContentChecks  checkResult        `json:"content_checks"`
+	PurchaseChecks checkResult        `json:"purchase_checks"`
+	CommentChecks  checkResult        `json:"comment_checks"`
+	Stats          statsSnapshot      `json:"stats"`
+}
+
+// totalScore is the sum of the scenario scores, rounded to an integer
+func (r loadReport) totalScore() int {
+	total := 0.0
+	for _, score := range r.ScenarioScores {
+		total += score
+	}
+	return int(math.Round(total))
+}
+
+func milliseconds(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
+
+// endpointResults converts the recorded statistics for the result file
+func (s *statsRecorder) endpointResults() ([]endpointResult, []targetResult, map[string]int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	endpoints := make([]endpointResult, 0, len(s.endpoints))
+	for _, name := range s.endpointNames() {
+		endpoints = append(endpoints, endpointResult{Endpoint: name, statsResult: s.endpoints[name].result()})
+	}
+	targets := make([]targetResult, 0, len(s.targets))
+	for _, name := range s.targetNames() {
+		targets = append(targets, targetResult{Target: name, statsResult: s.targets[name].result()})
+	}
+	errors := map[string]int{}
+	for status, n := range s.errorStatuses {
+		errors[statusLabel(status)] = n
+	}
+	return endpoints, targets, errors
+}
+
+func (e *endpointStats) result() statsResult {
+	return statsResult{
+		Requests: e.requests,
+		Errors:   e.errors,
+		P50Ms:    milliseconds(e.latency.percentile(50)),
+		P90Ms:    milliseconds(e.latency.percentile(90)),
+		P99Ms:    milliseconds(e.latency.percentile(99)),
+		MaxMs:    milliseconds(e.latency.max),
+	}
+}
+
+func statusLabel(status int) string {
+	if label, ok := statusLabels[status]; ok {
+		return label
+	}
+	return strconv.Itoa(status)
+}
+
+// Keep only the first failures of each kind of check for the report
+const maxReportedFailures = 20
+
+// checkTally counts the checks of one kind, keeping and logging the first failures
+type checkTally struct {
+	mu       sync.Mutex
+	label    string // log prefix of a failure
+	checked  int
+	failed   int
+	failures []validationFailure
+}
+
+// add counts one check; it failed when failures is not empty
+func (t *checkTally) add(failures []validationFailure) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.checked++
+	if len(failures) == 0 {
+		return
+	}
+	t.failed++
+	if len(t.failures) < maxReportedFailures {
+		t.failures = append(t.failures, failures...)
+	}
+	if t.failed <= maxReportedFailures {
+		for _, f := range failures {
+			log.Print(t.label + ": " + f.String())
+		}
+	}
+}
+
+func (t *checkTally) reset() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.checked, t.failed, t.failures = 0, 0, nil
+}
+
+func (t *checkTally) result() checkResult {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	failures := append([]validationFailure{}, t.failures...)
+	return checkResult{Checked: t.checked, Failed: t.failed, Failures: failures}
+}
+
+func writeResult(path string, r benchResult) error {
+	data, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, append(data, '\n'), 0644)
+}
@@ -2,182 +2,96 @@ package main
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"log"
 	"net/http"
 	"os"
 	"strconv"
 	"strings"
-	"sync"
 	"time"
 
 	_ "github.com/go-sql-driver/mysql"
 )
 
-/*
-Logs in and frequently accesses the product list page (including image loading).
-A user who puts load on the site but doesn't buy any products.
-*/
-func justLookingScenario(wg *sync.WaitGroup, m *sync.Mutex, finishTime time.Time) bool {
-	score := 0
-	resp := 200  //200 OK
-	var c []*http.Cookie  //HTTP request cookie
-
-	_, email, password := getUserInfo(0)
-	resp, c = postLogin(c, email, password)
-	score = calcScore(score, resp)
-
-	resp, c = getIndex(c, 0)
-	score = calcScore(score, resp)
-
-	for i := 0; i < 50; i++ {
-		resp, c = getImage(c, i%5)
-		score = calcScore(score, resp)
-	}
-	if updateScore(score, wg, m, finishTime) {
-		return true
-	}
-	score = 0
-
-	resp, c = getIndex(c, getRand(50, 99))
-	score = calcScore(score, resp)
-
-	resp, c = getIndex(c, getRand(100, 149))
-	score = calcScore(score, resp)
-
-	for i := 0; i < 50; i++ {
-		resp, c = getImage(c, i%5)
-		score = calcScore(score, resp)
-	}
-	if updateScore(score, wg, m, finishTime) {
-		return true
-	}
-	score = 0
-
-	// The reason getProduct(c, 0) is called three times in a row is to simulate real user behavior
-	resp, c = getIndex(c, getRand(150, 199))
-	score = calcScore(score, resp)
-
-	resp, c = getProduct(c, 0)
-	score = calcScore(score, resp)
-
-	resp, c = getProduct(c, 0)
-	score = calcScore(score, resp)
-
-	resp, c = getProduct(c, 0)
-	score = calcScore(score, resp)
-
-	resp, c = getLogout(c)
-	score = calcScore(score, resp)
-
-	return updateScore(score, wg, m, finishTime)
-}
-
-/*
-Accesses user pages frequently without logging in.
-A stalker who enjoys looking at other people's purchase history.
-*/
-func stalkerScenario(wg *sync.WaitGroup, m *sync.Mutex, finishTime time.Time) bool {
-	score := 0
-	resp := 200
-	var c []*http.Cookie
-
-	resp, c = getIndex(c, 0)
-	score = calcScore(score, resp)
-
-	// id:1234 A user who frequently buys products
-	resp, c = getUserPage(c, 1234)
-	score = calcScore(score, resp)
-
-	resp, c = getUserPage(c, 0)
-	score = calcScore(score, resp)
-
-	resp, c = getUserPage(c, 0)
-	score = calcScore(score, resp)
-
-	resp, c = getUserPage(c, 0)
-	score = calcScore(score, resp)
-
-	return updateScore(score, wg, m, finishTime)
-}
-
-/*
-Continuously buys products and leaves comments.
-A person from a rapidly growing economy who wants to buy high-quality products from developed countries.
-*/
-func bakugaiScenario(wg *sync.WaitGroup, m *sync.Mutex, finishTime time.Time) bool {
-	score := 0
-	resp := 200
-	var c []*http.Cookie
-
-	// 1/3 chance that user id:1234 goes on a shopping spree
-	uID := 0
-	if getRand(1, 2) == 1 {
-		uID = 1234
-	}
-
-	_, email, password := getUserInfo(uID)
-	resp, c = postLogin(c, email, password)
-	score = calcScore(score, resp)
-
-	resp, c = getIndex(c, getRand(100, 199))
-	score = calcScore(score, resp)
-
-	for i := 0; i < 20; i++ {
-		resp, c = buyProduct(c, 0)
-		score = calcScore(score, resp)
-	}
-	if updateScore(score, wg, m, finishTime) {
-		return true
-	}
-	score = 0
-
-	for i := 0; i < 5; i++ {
-		resp, c = sendComment(c, 0)
-		score = calcScore(score, resp)
-	}
-
-	resp, c = getLogout(c)
-	score = calcScore(score, resp)
-
-	return updateScore(score, wg, m, finishTime)
-}
+// The built-in scenarios, run by the engine in engine.go. A scenario file
+// given with --scenarios is written the same way.
+//
+// just: logs in and frequently accesses the product list page (including image loading).
+// A user who puts load on the site but doesn't buy any products.
+// The product page is opened three times in a row to simulate real user behavior.
+//
+// stalker: accesses user pages frequently without logging in.
+// A stalker who enjoys looking at other people's purchase history.
+// User 1234 is a user who frequently buys products.
+//
+// bakugai: continuously buys products and leaves comments.
+// A person from a rapidly growing economy who wants to buy high-quality products from developed countries.
+// Half of the time user 1234 goes on the shopping spree, and occasionally the
+// purchase history and the comments are checked.
+//
+// There is no scenario for favorites, ratings or category/tag browsing yet:
+// the webapp only reads the favorites, product_ratings and categories tables
+// and has no route that writes them (see webapp/src/index.ts). Add a "power"
+// scenario here, with a ledger like comments.go for the counts, once it does.
+const defaultScenarios = `{
+  "just": {
+    "description": "Browses the product list and images without buying",
+    "steps": [
+      {"action": "login"},
+      {"action": "index"},
+      {"action": "image", "params": {"id": {"cycle": [0, 4]}}, "repeat": 50},
+      {"action": "checkpoint"},
+      {"action": "index", "params": {"page": {"rand": [50, 99]}}},
+      {"action": "index", "params": {"page": {"rand": [100, 149]}}},
+      {"action": "image", "params": {"id": {"cycle": [0, 4]}}, "repeat": 50},
+      {"action": "checkpoint"},
+      {"action": "index", "params": {"page": {"rand": [150, 199]}}},
+      {"action": "product", "repeat": 3},
+      {"action": "logout"}
+    ]
+  },
+  "stalker": {
+    "description": "Looks at other users' purchase histories without logging in",
+    "steps": [
+      {"action": "index"},
+      {"action": "user", "params": {"id": 1234}},
+      {"action": "user", "repeat": 3}
+    ]
+  },
+  "bakugai": {
+    "description": "Buys products in bulk and leaves comments",
+    "steps": [
+      {"action": "login", "params": {"user": {"choice": [1234, 0]}}},
+      {"action": "index", "params": {"page": {"rand": [100, 199]}}},
+      {"action": "buy", "repeat": 20},
+      {"action": "verify_purchases", "one_in": 10},
+      {"action": "checkpoint"},
+      {"action": "comment", "repeat": 5},
+      {"action": "verify_comments", "one_in": 10},
+      {"action": "logout"}
+    ]
+  }
+}`
 
 // The following is for score calculation.
 // Return value: Whether this goroutine should terminate.
-func updateScore(score int, wg *sync.WaitGroup, m *sync.Mutex, finishTime time.Time) bool {
-	m.Lock()
-	defer m.Unlock()
-	totalScore = totalScore + score
-	if time.Now().After(finishTime) {
-		wg.Done()
-		if !finished {
-			finished = true
-			showScore()
-			postScore()
-		}
-		return true
-	}
-	return false
+func updateScore(ctx context.Context, scenario string, score float64) bool {
+	scores.add(scenario, score)
+	return ctx.Err() != nil
 }
 
-func calcScore(score int, response int) int {
-	if response == 200 {
-		return score + 1
-	} else if strings.Contains(strconv.Itoa(response), "4") {
-		return score - 20
-	} else {
-		return score - 50
-	}
+// calcScore adds the points of r under the scoring policy (see scoring.go)
+func calcScore(score float64, r response) float64 {
+	return score + scoring.points(r)
 }
 
-func showScore() {
+func showScore(totalScore int) {
 	log.Print("Benchmark Finish!")
 	log.Print("Score: " + strconv.Itoa(totalScore))
-	log.Print("Waiting for Stopping All Benchmarkers ...")
 }
 
-func postScore() {
+func postScore(totalScore int) {
 	apiURL := os.Getenv("BENCH_SCOREBOARD_APIGW_URL")
 	teamName := os.Getenv("BENCH_TEAM_NAME")
 	
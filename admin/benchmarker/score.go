@@ -0,0 +1,45 @@
+package main
+
+// scoreDelta is the score a scenario earned since its last flush
+type scoreDelta struct {
+	scenario string
+	score    float64
+}
+
+// scoreAggregator owns the score tally. Workers send deltas over a channel
+// and a single goroutine sums them up, so no lock is shared with the workers.
+type scoreAggregator struct {
+	deltas    chan scoreDelta
+	done      chan struct{}
+	scenarios map[string]float64
+}
+
+func newScoreAggregator() *scoreAggregator {
+	s := &scoreAggregator{
+		deltas:    make(chan scoreDelta, 1024),
+		done:      make(chan struct{}),
+		scenarios: map[string]float64{},
+	}
+	go func() {
+		defer close(s.done)
+		for d := range s.deltas {
+			s.scenarios[d.scenario] += d.score
+		}
+	}()
+	return s
+}
+
+func (s *scoreAggregator) add(scenario string, score float64) {
+	s.deltas <- scoreDelta{scenario: scenario, score: score}
+}
+
+// finalize must be called once, after every worker has stopped.
+// It returns the per-scenario scores.
+func (s *scoreAggregator) finalize() map[string]float64 {
+	close(s.deltas)
+	<-s.done
+	return s.scenarios
+}
+
+// Created by startBenchmark before the workers start
+var scores *scoreAggregator
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"sync"
+	"testing"
+)
+
+func TestScoreAggregatorConcurrentAdd(t *testing.T) {
+	const (
+		workers = 50
+		adds    = 200
+	)
+	s := newScoreAggregator()
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			scenario := "just"
+			if i%2 == 1 {
+				scenario = "bakugai"
+			}
+			for j := 0; j < adds; j++ {
+				s.add(scenario, 1.5)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	got := s.finalize()
+	want := float64(workers/2*adds) * 1.5
+	if got["just"] != want || got["bakugai"] != want || len(got) != 2 {
+		t.Errorf("finalize() = %v, want just=%g bakugai=%g", got, want, want)
+	}
+}
+
+func TestScoreAggregatorEmpty(t *testing.T) {
+	if got := newScoreAggregator().finalize(); len(got) != 0 {
+		t.Errorf("finalize() = %v, want no scores", got)
+	}
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"strings"
+)
+
+// scoringPolicy decides how much each response is worth.
+// It can be loaded from a JSON file with --scoring; see scoring.example.json.
+type scoringPolicy struct {
+	// Weight of a successful (2xx/3xx) response, by endpoint alias or route template
+	Weights map[string]float64 `json:"weights"`
+	// Weight of a successful response to an endpoint missing from Weights
+	DefaultWeight float64 `json:"default_weight"`
+
+	ClientErrorPenalty     float64 `json:"client_error_penalty"`     // 4xx
+	ServerErrorPenalty     float64 `json:"server_error_penalty"`     // 5xx
+	TimeoutPenalty         float64 `json:"timeout_penalty"`          // no response in time
+	ConnectionErrorPenalty float64 `json:"connection_error_penalty"` // refused, reset, TLS and DNS errors
+	InvalidContentPenalty  float64 `json:"invalid_content_penalty"`  // failed the in-load content check
+}
+
+// Short names usable as keys of scoringPolicy.Weights
+var endpointAliases = map[string]string{
+	"index":   "GET /",
+	"image":   "GET /images/:file",
+	"product": "GET /products/:id",
+	"user":    "GET /users/:id",
+	"login":   "POST /login",
+	"logout":  "GET /logout",
+	"buy":     "POST /products/buy/:id",
+	"comment": "POST /comments/:id",
+}
+
+func defaultScoringPolicy() *scoringPolicy {
+	return &scoringPolicy{
+		Weights:                map[string]float64{},
+		DefaultWeight:          1,
+		ClientErrorPenalty:     20,
+		ServerErrorPenalty:     50,
+		TimeoutPenalty:         50,
+		ConnectionErrorPenalty: 50,
+		InvalidContentPenalty:  50,
+	}
+}
+
+var scoring = defaultScoringPolicy()
+
+// loadScoringPolicy reads a policy file. Fields left out keep their defaults.
+func loadScoringPolicy(path string) (*scoringPolicy, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	p := defaultScoringPolicy()
+	d := json.NewDecoder(bytes.NewReader(b))
+	d.DisallowUnknownFields()
+	if err := d.Decode(p); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+
+	weights := map[string]float64{}
+	for key, w := range p.Weights {
+		if template, ok := endpointAliases[key]; ok {
+			key = template
+		} else if !isRouteTemplate(key) {
+			return nil, fmt.Errorf("%s: unknown endpoint %q (use one of %s or a route: %s)",
+				path, key, strings.Join(aliasNames(), ", "), strings.Join(routeTemplates(), ", "))
+		}
+		weights[key] = w
+	}
+	p.Weights = weights
+	return p, nil
+}
+
+// points returns what a single response adds to (or removes from) the score
+func (p *scoringPolicy) points(r response) float64 {
+	switch {
+	case r.status == statusCanceled:
+		return 0
+	case r.status == statusTimeout:
+		return -p.TimeoutPenalty
+	case isTransportError(r.status):
+		return -p.ConnectionErrorPenalty
+	case r.status == statusInvalidContent:
+		return -p.InvalidContentPenalty
+	case r.status >= 200 && r.status < 400:
+		if w, ok := p.Weights[r.endpoint]; ok {
+			return w
+		}
+		return p.DefaultWeight
+	case r.status >= 400 && r.status < 500:
+		return -p.ClientErrorPenalty
+	default:
+		return -p.ServerErrorPenalty
+	}
+}
+
+func (p *scoringPolicy) String() string {
+	keys := make([]string, 0, len(p.Weights))
+	for k := range p.Weights {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	parts := make([]string, 0, len(keys))
+	for _, k := range keys {
+		parts = append(parts, fmt.Sprintf("%s=%g", k, p.Weights[k]))
+	}
+	return fmt.Sprintf("default=%g, weights=[%s], penalties: 4xx=%g 5xx=%g timeout=%g connection=%g invalid=%g",
+		p.DefaultWeight, strings.Join(parts, ", "),
+		p.ClientErrorPenalty, p.ServerErrorPenalty, p.TimeoutPenalty, p.ConnectionErrorPenalty, p.InvalidContentPenalty)
+}
+
+// routeTemplates returns the routes the scenarios request, which are the
+// only ones scored
+func routeTemplates() []string {
+	routes := make([]string, 0, len(endpointAliases))
+	for _, route := range endpointAliases {
+		routes = append(routes, route)
+	}
+	sort.Strings(routes)
+	return routes
+}
+
+func isRouteTemplate(key string) bool {
+	for _, route := range endpointAliases {
+		if route == key {
+			return true
+		}
+	}
+	return false
+}
+
+func aliasNames() []string {
+	names := make([]string, 0, len(endpointAliases))
+	for name := range endpointAliases {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
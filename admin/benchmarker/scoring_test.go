@@ -0,0 +1,81 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func TestScoringPolicyPoints(t *testing.T) {
+	p := defaultScoringPolicy()
+	p.Weights["POST /products/buy/:id"] = 5
+	p.Weights["GET /images/:file"] = 0.1
+
+	for _, tt := range []struct {
+		r    response
+		want float64
+	}{
+		{response{endpoint: "GET /", status: 200}, 1},
+		{response{endpoint: "GET /logout", status: 303}, 1},
+		{response{endpoint: "POST /products/buy/:id", status: 200}, 5},
+		{response{endpoint: "GET /images/:file", status: 200}, 0.1},
+		{response{endpoint: "POST /products/buy/:id", status: 404}, -20},
+		{response{endpoint: "GET /", status: 500}, -50},
+		{response{endpoint: "GET /", status: statusTimeout}, -50},
+		{response{endpoint: "GET /", status: statusConnectionRefused}, -50},
+		{response{endpoint: "GET /", status: statusTLSError}, -50},
+		{response{endpoint: "GET /", status: statusInvalidContent}, -50},
+		{response{endpoint: "POST /products/buy/:id", status: statusCanceled}, 0},
+	} {
+		if got := p.points(tt.r); got != tt.want {
+			t.Errorf("points(%s %d) = %g, want %g", tt.r.endpoint, tt.r.status, got, tt.want)
+		}
+	}
+}
+
+// writeTempFile writes content to a new file; the caller removes it
+func writeTempFile(t *testing.T, content string) string {
+	t.Helper()
+	f, err := ioutil.TempFile("", "benchmarker")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if _, err := f.WriteString(content); err != nil {
+		t.Fatal(err)
+	}
+	return f.Name()
+}
+
+func TestLoadScoringPolicy(t *testing.T) {
+	path := writeTempFile(t, `{"weights": {"buy": 5, "GET /users/:id": 2}, "timeout_penalty": 10}`)
+	defer os.Remove(path)
+	p, err := loadScoringPolicy(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.Weights["POST /products/buy/:id"] != 5 || p.Weights["GET /users/:id"] != 2 {
+		t.Errorf("weights = %v, want the buy alias resolved", p.Weights)
+	}
+	if p.TimeoutPenalty != 10 || p.ServerErrorPenalty != 50 || p.DefaultWeight != 1 {
+		t.Errorf("penalties = %v, want the timeout set and the rest left at their defaults", p)
+	}
+
+	for _, content := range []string{
+		`{"weights": {"purchase": 5}}`,
+		`{"weights": {"GET /users/:name": 5}}`,
+		`{"weights": {"GET /admin": 5}}`,
+		`{"weight": {"buy": 5}}`,
+		`{"timeout_penalti": 10}`,
+	} {
+		path := writeTempFile(t, content)
+		defer os.Remove(path)
+		if _, err := loadScoringPolicy(path); err == nil {
+			t.Errorf("%s: want an error", content)
+		}
+	}
+
+	if _, err := loadScoringPolicy("scoring.example.json"); err != nil {
+		t.Errorf("scoring.example.json: %v", err)
+	}
+}
@@ -0,0 +1,278 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Latency histogram buckets: 1ms steps up to 100ms, 10ms steps up to 1s,
+// 100ms steps up to 30s, and a last bucket for anything slower.
+var latencyBuckets = func() []time.Duration {
+	var b []time.Duration
+	for d := time.Millisecond; d <= 100*time.Millisecond; d += time.Millisecond {
+		b = append(b, d)
+	}
+	for d := 110 * time.Millisecond; d <= time.Second; d += 10 * time.Millisecond {
+		b = append(b, d)
+	}
+	for d := 1100 * time.Millisecond; d <= 30*time.Second; d += 100 * time.Millisecond {
+		b = append(b, d)
+	}
+	return b
+}()
+
+type latencyHistogram struct {
+	counts []int
+	total  int
+	max    time.Duration
+}
+
+func newLatencyHistogram() latencyHistogram {
+	return latencyHistogram{counts: make([]int, len(latencyBuckets)+1)}
+}
+
+func (h *latencyHistogram) add(d time.Duration) {
+	i := sort.Search(len(latencyBuckets), func(i int) bool { return latencyBuckets[i] >= d })
+	h.counts[i]++
+	h.total++
+	if d > h.max {
+		h.max = d
+	}
+}
+
+// percentile returns the upper bound of the bucket holding the p-th percentile (0 < p <= 100)
+func (h *latencyHistogram) percentile(p float64) time.Duration {
+	if h.total == 0 {
+		return 0
+	}
+	rank := int(float64(h.total)*p/100 + 0.5)
+	if rank < 1 {
+		rank = 1
+	}
+	seen := 0
+	for i, c := range h.counts {
+		seen += c
+		if seen >= rank {
+			if i == len(latencyBuckets) || latencyBuckets[i] > h.max {
+				return h.max
+			}
+			return latencyBuckets[i]
+		}
+	}
+	return h.max
+}
+
+type endpointStats struct {
+	requests int
+	errors   int
+	latency  latencyHistogram
+}
+
+type statsRecorder struct {
+	mu        sync.Mutex
+	endpoints map[string]*endpointStats
+	// The same figures per target base URL
+	targets map[string]*endpointStats
+	// Number of failed requests per status code
+	errorStatuses map[int]int
+}
+
+var stats = &statsRecorder{endpoints: map[string]*endpointStats{}, targets: map[string]*endpointStats{}, errorStatuses: map[int]int{}}
+
+func (e *endpointStats) add(status int, elapsed time.Duration) {
+	e.requests++
+	if status >= 400 {
+		e.errors++
+	}
+	e.latency.add(elapsed)
+}
+
+func (s *statsRecorder) record(target string, endpoint string, status int, elapsed time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	statsEntry(s.endpoints, endpoint).add(status, elapsed)
+	statsEntry(s.targets, target).add(status, elapsed)
+	if status >= 400 {
+		s.errorStatuses[status]++
+	}
+}
+
+func statsEntry(m map[string]*endpointStats, key string) *endpointStats {
+	e, ok := m[key]
+	if !ok {
+		e = &endpointStats{latency: newLatencyHistogram()}
+		m[key] = e
+	}
+	return e
+}
+
+func (s *statsRecorder) reset() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.endpoints = map[string]*endpointStats{}
+	s.targets = map[string]*endpointStats{}
+	s.errorStatuses = map[int]int{}
+}
+
+// endpointNames returns the recorded endpoints in sorted order. Callers hold s.mu.
+func (s *statsRecorder) endpointNames() []string {
+	return sortedKeys(s.endpoints)
+}
+
+// targetNames returns the recorded targets in sorted order. Callers hold s.mu.
+func (s *statsRecorder) targetNames() []string {
+	return sortedKeys(s.targets)
+}
+
+func sortedKeys(m map[string]*endpointStats) []string {
+	names := make([]string, 0, len(m))
+	for name := range m {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+var (
+	numericSegment = regexp.MustCompile(`/[0-9]+(/|$)`)
+	imageFile      = regexp.MustCompile(`^/images/[^/]+$`)
+)
+
+// endpointName normalizes a request into its route template, e.g. "GET /users/:id"
+func endpointName(method string, path string) string {
+	if i := strings.IndexByte(path, '?'); i >= 0 {
+		path = path[:i]
+	}
+	if imageFile.MatchString(path) {
+		path = "/images/:file"
+	}
+	path = numericSegment.ReplaceAllString(path, "/:id$1")
+	return method + " " + path
+}
+
+func recordRequest(target string, method string, path string, status int, elapsed time.Duration) {
+	monitor.record(status, elapsed)
+	failGuard.record(status)
+	stats.record(target, endpointName(method, path), status, elapsed)
+}
+
+func showEndpointStats() {
+	stats.mu.Lock()
+	defer stats.mu.Unlock()
+
+	log.Print("Endpoint statistics:")
+	log.Printf("  %-28s %8s %7s %9s %9s %9s %9s", "ENDPOINT", "COUNT", "ERRORS", "P50", "P90", "P99", "MAX")
+	for _, name := range stats.endpointNames() {
+		showStatsRow(name, stats.endpoints[name])
+	}
+
+	if len(stats.targets) > 1 {
+		log.Print("Target statistics:")
+		log.Printf("  %-28s %8s %7s %9s %9s %9s %9s", "TARGET", "COUNT", "ERRORS", "P50", "P90", "P99", "MAX")
+		for _, name := range stats.targetNames() {
+			showStatsRow(name, stats.targets[name])
+		}
+	}
+
+	if len(stats.errorStatuses) == 0 {
+		return
+	}
+	statuses := make([]int, 0, len(stats.errorStatuses))
+	for status := range stats.errorStatuses {
+		statuses = append(statuses, status)
+	}
+	sort.Ints(statuses)
+	log.Print("Errors:")
+	for _, status := range statuses {
+		log.Printf("  %-28s %8d", statusLabel(status), stats.errorStatuses[status])
+	}
+}
+
+func showStatsRow(name string, e *endpointStats) {
+	log.Printf("  %-28s %8d %7d %9s %9s %9s %9s", name, e.requests, e.errors,
+		formatLatency(e.latency.percentile(50)),
+		formatLatency(e.latency.percentile(90)),
+		formatLatency(e.latency.percentile(99)),
+		formatLatency(e.latency.max))
+}
+
+func formatLatency(d time.Duration) string {
+	return fmt.Sprintf("%.1fms", float64(d)/float64(time.Millisecond))
+}
+
+// statsSnapshot carries the recorded statistics from a worker process to the coordinator
+type statsSnapshot struct {
+	Endpoints     map[string]histogramSnapshot `json:"endpoints"`
+	Targets       map[string]histogramSnapshot `json:"targets"`
+	ErrorStatuses map[int]int                  `json:"error_statuses"`
+}
+
+type histogramSnapshot struct {
+	Requests int           `json:"requests"`
+	Errors   int           `json:"errors"`
+	Counts   []int         `json:"counts"`
+	Max      time.Duration `json:"max"`
+}
+
+func (s *statsRecorder) snapshot() statsSnapshot {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	snap := statsSnapshot{
+		Endpoints:     map[string]histogramSnapshot{},
+		Targets:       map[string]histogramSnapshot{},
+		ErrorStatuses: map[int]int{},
+	}
+	for name, e := range s.endpoints {
+		snap.Endpoints[name] = e.snapshot()
+	}
+	for name, e := range s.targets {
+		snap.Targets[name] = e.snapshot()
+	}
+	for status, n := range s.errorStatuses {
+		snap.ErrorStatuses[status] = n
+	}
+	return snap
+}
+
+func (e *endpointStats) snapshot() histogramSnapshot {
+	return histogramSnapshot{
+		Requests: e.requests,
+		Errors:   e.errors,
+		Counts:   append([]int{}, e.latency.counts...),
+		Max:      e.latency.max,
+	}
+}
+
+// merge adds the statistics of another process
+func (s *statsRecorder) merge(snap statsSnapshot) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for name, h := range snap.Endpoints {
+		statsEntry(s.endpoints, name).merge(h)
+	}
+	for name, h := range snap.Targets {
+		statsEntry(s.targets, name).merge(h)
+	}
+	for status, n := range snap.ErrorStatuses {
+		s.errorStatuses[status] += n
+	}
+}
+
+func (e *endpointStats) merge(h histogramSnapshot) {
+	e.requests += h.Requests
+	e.errors += h.Errors
+	for i, c := range h.Counts {
+		if i < len(e.latency.counts) {
+			e.latency.counts[i] += c
+			e.latency.total += c
+		}
+	}
+	if h.Max > e.latency.max {
+		e.latency.max = h.Max
+	}
+}
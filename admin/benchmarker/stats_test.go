@@ -0,0 +1,54 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLatencyHistogramPercentile(t *testing.T) {
+	h := newLatencyHistogram()
+	if got := h.percentile(50); got != 0 {
+		t.Errorf("empty histogram: percentile(50) = %v, want 0", got)
+	}
+
+	for i := 1; i <= 100; i++ {
+		h.add(time.Duration(i) * time.Millisecond)
+	}
+	for _, tt := range []struct {
+		p    float64
+		want time.Duration
+	}{
+		{0.1, 1 * time.Millisecond},
+		{50, 50 * time.Millisecond},
+		{90, 90 * time.Millisecond},
+		{99, 99 * time.Millisecond},
+		{100, 100 * time.Millisecond},
+	} {
+		if got := h.percentile(tt.p); got != tt.want {
+			t.Errorf("1..100ms: percentile(%g) = %v, want %v", tt.p, got, tt.want)
+		}
+	}
+}
+
+func TestLatencyHistogramPercentileBounds(t *testing.T) {
+	for _, tt := range []struct {
+		name    string
+		samples []time.Duration
+		p       float64
+		want    time.Duration
+	}{
+		// The bucket bound is never above the slowest request
+		{"below bucket bound", []time.Duration{3500 * time.Microsecond}, 50, 3500 * time.Microsecond},
+		{"10ms buckets", []time.Duration{245 * time.Millisecond, 900 * time.Millisecond}, 50, 250 * time.Millisecond},
+		{"100ms buckets", []time.Duration{1210 * time.Millisecond, 5 * time.Second}, 50, 1300 * time.Millisecond},
+		{"beyond the last bucket", []time.Duration{time.Millisecond, 45 * time.Second}, 99, 45 * time.Second},
+	} {
+		h := newLatencyHistogram()
+		for _, d := range tt.samples {
+			h.add(d)
+		}
+		if got := h.percentile(tt.p); got != tt.want {
+			t.Errorf("%s: percentile(%g) = %v, want %v", tt.name, tt.p, got, tt.want)
+		}
+	}
+}
@@ -2,36 +2,207 @@ package main
 
 import (
 	"math/rand"
+	"strconv"
+	"sync"
 
 	_ "github.com/go-sql-driver/mysql"
 )
 
-func choice(s []string) string {
-	i := rand.Intn(len(s))
+func choice(r *rand.Rand, s []string) string {
+	i := r.Intn(len(s))
 	return s[i]
 }
 
-// Get user information randomly
-func getUserInfo(id int) (int, string, string) {
-	if id == 0 {
-		id = getRand(1, 5000)
+type userCredential struct {
+	email    string
+	password string
+}
+
+var (
+	usersOnce sync.Once
+	users     map[int]userCredential
+)
+
+// Load the credentials of all users once so scenarios do not hit MySQL
+func loadUsers() {
+	usersOnce.Do(func() {
+		users = map[int]userCredential{}
+		if fixtureData != nil {
+			for _, u := range fixtureData.Users {
+				users[u.ID] = userCredential{email: u.Email, password: u.Password}
+			}
+			return
+		}
+
+		db, err := getDB()
+		if err != nil {
+			panic(err.Error())
+		}
+		rows, err := db.Query("SELECT id, email, password FROM users WHERE id <= 5000")
+		if err != nil {
+			panic(err.Error())
+		}
+		defer rows.Close()
+
+		for rows.Next() {
+			var id int
+			var u userCredential
+			if err := rows.Scan(&id, &u.email, &u.password); err != nil {
+				panic(err.Error())
+			}
+			users[id] = u
+		}
+	})
+}
+
+// Get user information randomly. A user outside this process's share is replaced by a random one.
+func getUserInfo(r *rand.Rand, id int) (int, string, string) {
+	if id == 0 || !share.ownsUser(id) {
+		id = share.user(r)
 	}
-	var email, password string
-	db, err := getDB()
-	if err != nil {
-		panic(err.Error())
+	loadUsers()
+	u, ok := users[id]
+	if !ok {
+		panic("unknown user id: " + strconv.Itoa(id))
 	}
-	defer db.Close()
+	return id, u.email, u.password
+}
 
-	err = db.QueryRow("SELECT email, password FROM users WHERE id = ? LIMIT 1", id).Scan(&email, &password)
-	if err != nil {
-		panic(err.Error())
-	}
+// Get a random value from from to to
+func getRand(r *rand.Rand, from int, to int) int {
+	return r.Intn(to+1-from) + from
+}
+
+var (
+	productPricesOnce sync.Once
+	productPrices     map[int]int
+)
+
+// Get the price of a product; all prices are loaded on the first call
+func getProductPrice(id int) int {
+	productPricesOnce.Do(func() {
+		productPrices = map[int]int{}
+		if fixtureData != nil {
+			for _, p := range fixtureData.Products {
+				productPrices[p.ID] = p.Price
+			}
+			return
+		}
 
-	return id, email, password
+		db, err := getDB()
+		if err != nil {
+			panic(err.Error())
+		}
+		rows, err := db.Query("SELECT id, price FROM products")
+		if err != nil {
+			panic(err.Error())
+		}
+		defer rows.Close()
+
+		for rows.Next() {
+			var pid, price int
+			if err := rows.Scan(&pid, &price); err != nil {
+				panic(err.Error())
+			}
+			productPrices[pid] = price
+		}
+	})
+	return productPrices[id]
 }
 
-// Get a random value from from to to
-func getRand(from int, to int) int {
-	return rand.Intn(to+1-from) + from
+var (
+	totalPaysOnce sync.Once
+	totalPays     map[int]int
+)
+
+// Get the total purchase amount of a user right after GET /initialize;
+// all totals are loaded on the first call
+func getTotalPay(userID int) int {
+	totalPaysOnce.Do(func() {
+		totalPays = map[int]int{}
+		if fixtureData != nil {
+			for _, u := range fixtureData.Users {
+				totalPays[u.ID] = u.TotalPay
+			}
+			return
+		}
+
+		db, err := getDB()
+		if err != nil {
+			panic(err.Error())
+		}
+		totals, err := queryTotalPays(db)
+		if err != nil {
+			panic(err.Error())
+		}
+		totalPays = totals
+	})
+	return totalPays[userID]
+}
+
+var (
+	commentCountsOnce sync.Once
+	commentCounts     map[int]int
+)
+
+// Get the number of comments on a product right after GET /initialize;
+// all counts are loaded on the first call
+func getCommentCount(productID int) int {
+	commentCountsOnce.Do(func() {
+		commentCounts = map[int]int{}
+		if fixtureData != nil {
+			for _, p := range fixtureData.Products {
+				commentCounts[p.ID] = p.Comments
+			}
+			return
+		}
+
+		db, err := getDB()
+		if err != nil {
+			panic(err.Error())
+		}
+		counts, err := queryCommentCounts(db)
+		if err != nil {
+			panic(err.Error())
+		}
+		commentCounts = counts
+	})
+	return commentCounts[productID]
+}
+
+// workShare is the part of the users and products this process picks from.
+// In distributed mode every user's purchases and every product's comments
+// come from one process, so the ledgers of each process stay complete.
+type workShare struct {
+	index int // 0..count-1
+	count int
+	// Used by the coordinator's validation, so left out of the load
+	excludedUsers    map[int]bool
+	excludedProducts map[int]bool
+}
+
+// The whole data set, unless a coordinator assigns a share
+var share = &workShare{count: 1}
+
+func (s *workShare) ownsUser(id int) bool {
+	return (id-1)%s.count == s.index && !s.excludedUsers[id]
+}
+
+// user returns a random user id of the share
+func (s *workShare) user(r *rand.Rand) int {
+	return s.pick(r, 5000, s.excludedUsers)
+}
+
+// product returns a random product id of the share
+func (s *workShare) product(r *rand.Rand) int {
+	return s.pick(r, 10000, s.excludedProducts)
+}
+
+func (s *workShare) pick(r *rand.Rand, max int, excluded map[int]bool) int {
+	for {
+		id := s.index + 1 + s.count*r.Intn((max-1-s.index)/s.count+1)
+		if !excluded[id] {
+			return id
+		}
+	}
 }
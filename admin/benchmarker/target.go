@@ -0,0 +1,207 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// hostTransport sends every request, redirects included, with the Host
+// header given by --host-header
+type hostTransport struct {
+	host string
+	next http.RoundTripper
+}
+
+func (t *hostTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if t.host != "" && req.Host != t.host {
+		req = req.Clone(req.Context())
+		req.Host = t.host
+	}
+	return t.next.RoundTrip(req)
+}
+
+// Used by every client that talks to the target
+var targetTransport = &hostTransport{next: transport}
+
+// targetOptions are the flags that decide where and how requests are sent
+type targetOptions struct {
+	ip         *string
+	target     *string
+	balance    *string
+	insecure   *bool
+	caCert     *string
+	serverName *string
+	hostHeader *string
+}
+
+func addTargetFlags(fs *flag.FlagSet) *targetOptions {
+	return &targetOptions{
+		ip:         fs.String("ip", getEnv("BENCH_IP", "127.0.0.1"), ""),
+		target:     fs.String("target", getEnv("BENCH_TARGET", ""), ""),
+		balance:    fs.String("balance", getEnv("BENCH_BALANCE", balanceRoundRobin), ""),
+		insecure:   fs.Bool("insecure", getEnvBool("BENCH_INSECURE", false), ""),
+		caCert:     fs.String("ca-cert", getEnv("BENCH_CA_CERT", ""), ""),
+		serverName: fs.String("server-name", getEnv("BENCH_SERVER_NAME", ""), ""),
+		hostHeader: fs.String("host-header", getEnv("BENCH_HOST_HEADER", ""), ""),
+	}
+}
+
+// apply sets the targets and the TLS settings of the shared transport.
+// --target, when given, takes precedence over --ip.
+func (o *targetOptions) apply() error {
+	var (
+		targets weightedList
+		err     error
+	)
+	if *o.target != "" {
+		targets, err = parseTargets(*o.target, "")
+	} else {
+		targets, err = parseTargets(*o.ip, "http://")
+	}
+	if err != nil {
+		return err
+	}
+	if balancer, err = newTargetBalancer(*o.balance, targets); err != nil {
+		return err
+	}
+	// The validator, GET /initialize and the final checks use the first target
+	host = targets[0].name
+
+	config := &tls.Config{InsecureSkipVerify: *o.insecure, ServerName: *o.serverName}
+	if config.ServerName == "" && *o.hostHeader != "" {
+		// Ask for the certificate of the virtual host the requests are meant for
+		config.ServerName = *o.hostHeader
+		if h, _, err := net.SplitHostPort(*o.hostHeader); err == nil {
+			config.ServerName = h
+		}
+	}
+	if *o.caCert != "" {
+		pem, err := ioutil.ReadFile(*o.caCert)
+		if err != nil {
+			return err
+		}
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return fmt.Errorf("%s: no PEM certificates found", *o.caCert)
+		}
+		config.RootCAs = pool
+	}
+	transport.TLSClientConfig = config
+	targetTransport.host = *o.hostHeader
+	return nil
+}
+
+// String describes the targets for the log
+func (o *targetOptions) String() string {
+	s := balancer.String()
+	if *o.hostHeader != "" {
+		s += " (Host: " + *o.hostHeader + ")"
+	}
+	if *o.insecure {
+		s += " (certificate not verified)"
+	}
+	return s
+}
+
+// parseTargets parses "URL[=WEIGHT],URL[=WEIGHT],...". Each URL gets
+// prefix first, so that --ip can take bare addresses.
+func parseTargets(list string, prefix string) (weightedList, error) {
+	var targets weightedList
+	for _, part := range strings.Split(list, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		weight := 1
+		if i := strings.LastIndex(part, "="); i >= 0 {
+			w, err := strconv.Atoi(part[i+1:])
+			if err != nil || w <= 0 {
+				return nil, fmt.Errorf("invalid weight for %q: %q", part[:i], part[i+1:])
+			}
+			part, weight = part[:i], w
+		}
+
+		u, err := url.Parse(prefix + part)
+		if err != nil {
+			return nil, err
+		}
+		if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" || u.RawQuery != "" || u.Fragment != "" {
+			return nil, fmt.Errorf("not an http:// or https:// URL without query: %s", part)
+		}
+		base := u.Scheme + "://" + u.Host + strings.TrimSuffix(u.Path, "/")
+		for _, t := range targets {
+			if t.name == base {
+				return nil, fmt.Errorf("target %s is listed twice", base)
+			}
+		}
+		targets = append(targets, weightedItem{name: base, weight: weight})
+	}
+	if len(targets) == 0 {
+		return nil, fmt.Errorf("no target")
+	}
+	return targets, nil
+}
+
+// How virtual users are spread over the targets
+const (
+	balanceRoundRobin = "round-robin" // every new session goes to the next target
+	balanceWeighted   = "weighted"    // new sessions follow the target weights
+	balanceSticky     = "sticky"      // each virtual user keeps one target, assigned by weight
+)
+
+// targetBalancer hands out targets with a weightedPicker, like the scenario
+// mix; round-robin simply gives every target the same weight.
+type targetBalancer struct {
+	mu      sync.Mutex
+	mode    string
+	targets weightedList
+	picker  *weightedPicker
+}
+
+// Set by targetOptions.apply
+var balancer *targetBalancer
+
+func newTargetBalancer(mode string, targets weightedList) (*targetBalancer, error) {
+	weighted := targets
+	switch mode {
+	case balanceRoundRobin:
+		weighted = make(weightedList, len(targets))
+		for i, t := range targets {
+			weighted[i] = weightedItem{name: t.name, weight: 1}
+		}
+	case balanceWeighted, balanceSticky:
+	default:
+		return nil, fmt.Errorf("unknown balance %q (choose from %s, %s, %s)", mode, balanceRoundRobin, balanceWeighted, balanceSticky)
+	}
+	return &targetBalancer{mode: mode, targets: targets, picker: weighted.picker()}, nil
+}
+
+func (b *targetBalancer) pick() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.picker.next()
+}
+
+// perSession reports whether a virtual user picks a new target with every session
+func (b *targetBalancer) perSession() bool {
+	return b.mode != balanceSticky
+}
+
+func (b *targetBalancer) String() string {
+	if len(b.targets) == 1 {
+		return b.targets[0].name
+	}
+	return b.targets.String() + " (" + b.mode + ")"
+}
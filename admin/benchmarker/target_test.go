@@ -0,0 +1,76 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseTargets(t *testing.T) {
+	for _, tt := range []struct {
+		list   string
+		prefix string
+		want   weightedList
+	}{
+		{"127.0.0.1", "http://", weightedList{{"http://127.0.0.1", 1}}},
+		{"127.0.0.1:8080, 10.0.0.2", "http://", weightedList{{"http://127.0.0.1:8080", 1}, {"http://10.0.0.2", 1}}},
+		{"https://example.com/", "", weightedList{{"https://example.com", 1}}},
+		{"https://example.com/app/", "", weightedList{{"https://example.com/app", 1}}},
+		{"http://a:80=3,https://b=1,", "", weightedList{{"http://a:80", 3}, {"https://b", 1}}},
+	} {
+		got, err := parseTargets(tt.list, tt.prefix)
+		if err != nil {
+			t.Errorf("parseTargets(%q, %q): %v", tt.list, tt.prefix, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("parseTargets(%q, %q) = %v, want %v", tt.list, tt.prefix, got, tt.want)
+		}
+	}
+}
+
+func TestParseTargetsErrors(t *testing.T) {
+	for _, list := range []string{
+		"",
+		" , ",
+		"ftp://example.com",
+		"example.com",
+		"http://",
+		"http://example.com/?debug=1",
+		"http://example.com/#top",
+		"http://a,http://a/",
+		"http://a=0",
+		"http://a=-1",
+		"http://a=x",
+	} {
+		if got, err := parseTargets(list, ""); err == nil {
+			t.Errorf("parseTargets(%q) = %v, want an error", list, got)
+		}
+	}
+}
+
+func TestTargetBalancer(t *testing.T) {
+	targets := weightedList{{"http://a", 3}, {"http://b", 1}}
+	for _, tt := range []struct {
+		mode string
+		want []string
+	}{
+		{balanceRoundRobin, []string{"http://a", "http://b", "http://a", "http://b"}},
+		{balanceWeighted, []string{"http://a", "http://a", "http://b", "http://a"}},
+	} {
+		b, err := newTargetBalancer(tt.mode, targets)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got []string
+		for range tt.want {
+			got = append(got, b.pick())
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: picked %v, want %v", tt.mode, got, tt.want)
+		}
+	}
+
+	if _, err := newTargetBalancer("random", targets); err == nil {
+		t.Error("unknown balance: want an error")
+	}
+}
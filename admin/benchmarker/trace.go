@@ -0,0 +1,111 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/url"
+	"os"
+	"sync"
+	"time"
+)
+
+// traceEntry is one line of a --record file
+type traceEntry struct {
+	Agent     int     `json:"agent"`
+	Session   int     `json:"session"` // of the agent; each one starts with a new cookie jar
+	Method    string  `json:"method"`
+	Path      string  `json:"path"`
+	Form      string  `json:"form,omitempty"` // URL-encoded
+	OffsetMs  float64 `json:"offset_ms"`      // since the load phase started
+	Status    int     `json:"status"`
+	LatencyMs float64 `json:"latency_ms"`
+}
+
+// traceRecorder writes every request of the scenario workers as JSON lines
+type traceRecorder struct {
+	mu    sync.Mutex
+	file  *os.File
+	w     *bufio.Writer
+	enc   *json.Encoder
+	start time.Time
+	err   error // the first write error
+}
+
+// Set by --record; nil means requests are not recorded
+var tracer *traceRecorder
+
+func newTraceRecorder(path string) (*traceRecorder, error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	w := bufio.NewWriter(f)
+	return &traceRecorder{file: f, w: w, enc: json.NewEncoder(w), start: time.Now()}, nil
+}
+
+// begin sets the time offsets are measured from
+func (t *traceRecorder) begin(start time.Time) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.start = start
+}
+
+// record writes a request of a scenario worker. Requests of other agents
+// (the validator, GET /initialize) are not part of the trace.
+func (t *traceRecorder) record(a *agent, method string, path string, params url.Values, startTime time.Time, status int, elapsed time.Duration) {
+	if t == nil || a.id == 0 {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.err != nil {
+		return
+	}
+	t.err = t.enc.Encode(traceEntry{
+		Agent:     a.id,
+		Session:   a.session,
+		Method:    method,
+		Path:      path,
+		Form:      params.Encode(),
+		OffsetMs:  milliseconds(startTime.Sub(t.start)),
+		Status:    status,
+		LatencyMs: milliseconds(elapsed),
+	})
+}
+
+func (t *traceRecorder) close() error {
+	if t == nil {
+		return nil
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if err := t.w.Flush(); err != nil && t.err == nil {
+		t.err = err
+	}
+	if err := t.file.Close(); err != nil && t.err == nil {
+		t.err = err
+	}
+	return t.err
+}
+
+func loadTrace(path string) ([]traceEntry, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var entries []traceEntry
+	dec := json.NewDecoder(f)
+	for dec.More() {
+		var e traceEntry
+		if err := dec.Decode(&e); err != nil {
+			return nil, err
+		}
+		entries = append(entries, e)
+	}
+	return entries, nil
+}
@@ -1,9 +1,12 @@
 package main
 
 import (
+	"context"
+	"fmt"
 	"log"
+	"math/rand"
 	"net/http"
-	"os"
+	"regexp"
 	"strconv"
 	"strings"
 	"time"
@@ -12,50 +15,94 @@ import (
 	_ "github.com/go-sql-driver/mysql"
 )
 
-func validateInitialize() {
+// validationFailure is a single failed check of the validation phase
+type validationFailure struct {
+	Endpoint string `json:"endpoint"`
+	Check    string `json:"check"`
+	Expected string `json:"expected"`
+	Actual   string `json:"actual"`
+}
+
+func (f validationFailure) String() string {
+	return fmt.Sprintf("%s: %s (expected=%q, actual=%q)", f.Endpoint, f.Check, f.Expected, f.Actual)
+}
+
+// failuresOf returns a function that appends a failed check of endpoint to failures
+func failuresOf(endpoint string, failures *[]validationFailure) func(check, expected, actual string) {
+	return func(check, expected, actual string) {
+		*failures = append(*failures, validationFailure{Endpoint: endpoint, Check: check, Expected: expected, Actual: actual})
+	}
+}
+
+// validateInitialize runs every check and returns all failures; an empty list means the app passed
+func validateInitialize() []validationFailure {
+	failures := []validationFailure{}
 	log.Print("Validation: Initializing data...")
 	initializeData()
-	
+
 	log.Print("Validation: Checking GET /index (page=10)...")
-	validateIndex(10, false)
-	
+	failures = append(failures, validateIndex(10, false)...)
+
 	log.Print("Validation: Checking GET /products/:id...")
-	validateProducts(false)
-	
+	failures = append(failures, validateProducts(false)...)
+
 	log.Print("Validation: Checking GET /users/1500...")
-	validateUsers(1500, false)
-	
-	userId, email, password := getUserInfo(0)
+	failures = append(failures, validateUsers(1500, false)...)
+
+	a := newAgent(rand.Int63())
+	userId, email, password := getUserInfo(a.rand, 0)
 	log.Printf("Validation: Running login and purchase test with user %d...", userId)
-	var c []*http.Cookie
-	resp, c := postLogin(c, email, password)
-	if resp != 200 && resp != 303 {
-		log.Printf("Error: Login failed (status=%d, email=%s)", resp, email)
+	ctx := context.Background()
+	resp := postLogin(ctx, a, email, password)
+	if resp.status != 200 && resp.status != 303 {
+		failures = append(failures, validationFailure{
+			Endpoint: "POST /login",
+			Check:    "login (email=" + email + ")",
+			Expected: "200 or 303",
+			Actual:   statusLabel(resp.status),
+		})
 	}
-	
-	resp, c = buyProductForValidation(c, userId, 10000)
-	if resp != 200 && resp != 303 {
-		log.Printf("Error: Product purchase failed (status=%d, userId=%d, productId=10000)", resp, userId)
+
+	resp = buyProductForValidation(ctx, a, userId, 10000)
+	if resp.status != 200 && resp.status != 303 {
+		failures = append(failures, validationFailure{
+			Endpoint: "POST /products/buy/10000",
+			Check:    "purchase (userId=" + strconv.Itoa(userId) + ")",
+			Expected: "200 or 303",
+			Actual:   statusLabel(resp.status),
+		})
 	}
-	
+
 	log.Printf("Validation: Checking GET /users/%d (after login)...", userId)
-	validateUsers(userId, true)
-	
+	failures = append(failures, validateUsers(userId, true)...)
+
 	log.Print("Validation: Running comment posting test...")
-	sendComment(c, 10000)
-	
+	sendComment(ctx, a, userId, 10000)
+
 	log.Print("Validation: Checking GET /index (page=0, after login)...")
-	validateIndex(0, true)
-	
+	failures = append(failures, validateIndex(0, true)...)
+
 	log.Print("Validation: All checks completed")
+	return failures
+}
+
+func showValidationFailures(failures []validationFailure) {
+	log.Printf("Validation failed: %d check(s)", len(failures))
+	for _, f := range failures {
+		log.Print("  " + f.String())
+	}
 }
 
 func initializeData() {
+	if fixtureData != nil {
+		// GET /initialize has already reset the same tables on the app side
+		log.Print("Validation: Skipping direct DB cleanup (fixture mode)")
+		return
+	}
 	db, err := getDB()
 	if err != nil {
 		panic(err.Error())
 	}
-	defer db.Close()
 
 	_, err = db.Exec("DELETE FROM histories WHERE id > 500000")
 	if err != nil {
@@ -73,287 +120,219 @@ func initializeData() {
 	db.Exec("DELETE FROM user_coupons WHERE id > 0")
 }
 
-func validateIndex(page int, loggedIn bool) {
-	var flg, flg1, flg2, flg3 bool
-	var flg50, flgOrder, flgReview, flgPrdExp bool
-	resp, err := http.Get(host + "/?page=" + strconv.Itoa(page))
+// fetchDocument GETs path and parses it, or returns why it could not
+func fetchDocument(ctx context.Context, path string) (*goquery.Document, []validationFailure) {
+	endpoint := "GET " + path
+	req, _ := http.NewRequest("GET", host+path, nil)
+	resp, err := anonymousClient.Do(req.WithContext(ctx))
 	if err != nil {
-		log.Print("Cannot GET /index")
-		os.Exit(1)
+		return nil, []validationFailure{{Endpoint: endpoint, Check: "request", Expected: "response", Actual: err.Error()}}
 	}
 	defer resp.Body.Close()
-	
+
 	if resp.StatusCode != http.StatusOK {
-		log.Printf("GET /index returned status %d", resp.StatusCode)
-		os.Exit(1)
+		return nil, []validationFailure{{Endpoint: endpoint, Check: "status code", Expected: "200", Actual: strconv.Itoa(resp.StatusCode)}}
 	}
-	
+
 	doc, err := goquery.NewDocumentFromReader(resp.Body)
 	if err != nil {
-		log.Print("Cannot parse HTML")
-		os.Exit(1)
+		return nil, []validationFailure{{Endpoint: endpoint, Check: "parse HTML", Expected: "valid HTML", Actual: err.Error()}}
 	}
+	return doc, nil
+}
 
-	// Verify that there are 50 products
-	flg50 = doc.Find(".row").Children().Size() == 50
+func validateIndex(page int, loggedIn bool) []validationFailure {
+	path := "/?page=" + strconv.Itoa(page)
+	doc, failures := fetchDocument(context.Background(), path)
+	if doc == nil {
+		return failures
+	}
+	failures = append(failures, checkIndexDocument(doc, "GET "+path, page)...)
+	fail := failuresOf("GET "+path, &failures)
 
-	// Verify that products are ordered by id DESC
-	doc.Find("a").EachWithBreak(func(i int, s *goquery.Selection) bool {
-		// Also check login button
-		if i == 1 {
-			ref, _ := s.Attr("href")
-			flg1 = ref == "/login"
-		}
-		if i == 2 {
-			ref, _ := s.Attr("href")
-			flg2 = ref == "/products/"+strconv.Itoa(10000-page*50)
-		}
-		if i == 10 {
-			ref, _ := s.Attr("href")
-			flg3 = ref == "/products/"+strconv.Itoa(10000-page*50-4)
-			return false
-		}
-		return true
-	})
-	flgOrder = flg1 && flg2 && flg3
+	// The page is fetched without a session, so the 2nd link is the login button
+	if href, _ := doc.Find("a").Eq(1).Attr("href"); href != "/login" {
+		fail("login link", "/login", href)
+	}
+	if n := doc.Find(".panel-default").First().Children().Size(); n != 2 {
+		fail("product DOM", ".panel-default=2", ".panel-default="+strconv.Itoa(n))
+	}
 
 	// Verify that the number of reviews is correct
-	doc.Find("h4").EachWithBreak(func(i int, s *goquery.Selection) bool {
-		if i == 2 {
-			str := s.Text()
-			if loggedIn {
-				flg1 = str == "21件のレビュー"
-			} else {
-				flg1 = str == "20件のレビュー"
-			}
-		}
-		if i == 11 {
-			str := s.Text()
-			flg2 = str == "20件のレビュー"
-			return false
+	expectedReviews := map[int]string{2: "20件のレビュー", 11: "20件のレビュー"}
+	if loggedIn {
+		expectedReviews[2] = "21件のレビュー"
+	}
+	for _, i := range []int{2, 11} {
+		if actual := doc.Find("h4").Eq(i).Text(); actual != expectedReviews[i] {
+			fail("number of reviews (h4 #"+strconv.Itoa(i)+")", expectedReviews[i], actual)
 		}
-		return true
-	})
-	flgReview = flg1 && flg2
+	}
+
+	return failures
+}
 
-	// Verify product DOM structure
-	flg1 = doc.Find(".panel-default").First().Children().Size() == 2
-	flg2 = doc.Find(".panel-body").First().Children().Size() == 7
-	flg3 = doc.Find(".col-md-4").First().Find(".panel-body ul").Children().Size() == 5
-	flgPrdExp = flg1 && flg2 && flg3
+var reviewCountText = regexp.MustCompile(`^[0-9]+件のレビュー$`)
 
-	// Verify image paths
-	doc.Find("img").EachWithBreak(func(i int, s *goquery.Selection) bool {
-		if i == 0 || i == 6 || i == 12 || i == 18 || i == 24 {
-			src, _ := s.Attr("src")
-			flg1 = src == "/images/image"+strconv.Itoa((99-i)%5)+".jpg"
-			flgPrdExp = flgPrdExp && flg1
+// checkIndexDocument runs the checks of GET /?page= that hold for any session and at any time
+func checkIndexDocument(doc *goquery.Document, endpoint string, page int) []validationFailure {
+	var failures []validationFailure
+	fail := failuresOf(endpoint, &failures)
+
+	// Verify that there are 50 products
+	if n := doc.Find(".row").Children().Size(); n != 50 {
+		fail("number of products", "50", strconv.Itoa(n))
+	}
+
+	// Verify that products are ordered by id DESC
+	for _, i := range []int{0, 4} {
+		expected := "/products/" + strconv.Itoa(10000-page*50-i)
+		if href, _ := doc.Find(".panel-heading a").Eq(i).Attr("href"); href != expected {
+			fail("product order (product #"+strconv.Itoa(i)+")", expected, href)
 		}
-		if i == 24 {
+	}
+
+	// Verify product DOM structure, reporting the first broken product only
+	doc.Find(".panel-body").EachWithBreak(func(i int, s *goquery.Selection) bool {
+		children := s.Children().Size()
+		comments := s.Find("ul").Children().Size()
+		if children != 7 || comments > 5 {
+			fail("product DOM (product #"+strconv.Itoa(i)+")", ".panel-body=7, comments<=5",
+				fmt.Sprintf(".panel-body=%d, comments=%d", children, comments))
+			return false
+		}
+		if review := s.Find("h4").Eq(2).Text(); !reviewCountText.MatchString(review) {
+			fail("review count (product #"+strconv.Itoa(i)+")", "N件のレビュー", review)
 			return false
 		}
 		return true
 	})
+	if n := doc.Find(".col-md-4").First().Find(".panel-body ul").Children().Size(); n != 5 {
+		fail("number of comments", "5", strconv.Itoa(n))
+	}
 
-	flg = flg50 && flgOrder && flgReview && flgPrdExp
-	// Overall verification
-	if flg == false {
-		log.Print("Invalid Content or DOM at GET /index")
-		log.Printf("  page=%d, loggedIn=%v", page, loggedIn)
-		if flg50 == false {
-			log.Printf("  50 products are not displayed (got=%d)", doc.Find(".row").Children().Size())
-		}
-		if flgOrder == false {
-			log.Print("  Products are not in the correct order")
-		}
-		if flgReview == false {
-			expectedReview := "20件のレビュー"
-			if loggedIn {
-				expectedReview = "21件のレビュー"
-			}
-			log.Printf("  Number of reviews is incorrect (expected='%s')", expectedReview)
-		}
-		if flgPrdExp == false {
-			log.Print("  Product description section is incorrect")
+	// Verify image paths
+	for _, i := range []int{0, 6, 12, 18, 24} {
+		expected := "/images/image" + strconv.Itoa((99-i)%5) + ".jpg"
+		if src, _ := doc.Find(".panel-body img").Eq(i).Attr("src"); src != expected {
+			fail("image path (product #"+strconv.Itoa(i)+")", expected, src)
 		}
-		os.Exit(1)
 	}
+
+	return failures
 }
 
-func validateProducts(loggedIn bool) {
-	var flg bool
-	var flgImage, flgPrdExp bool
-	var actualImageSrc string
-	resp, err := http.Get(host + "/products/1500")
-	if err != nil {
-		log.Print("Cannot GET /products/:id")
-		os.Exit(1)
+func validateProducts(loggedIn bool) []validationFailure {
+	path := "/products/1500"
+	doc, failures := fetchDocument(context.Background(), path)
+	if doc == nil {
+		return failures
 	}
-	defer resp.Body.Close()
-	
-	if resp.StatusCode != http.StatusOK {
-		log.Printf("GET /products/:id returned status %d", resp.StatusCode)
-		os.Exit(1)
-	}
-	
-	doc, err := goquery.NewDocumentFromReader(resp.Body)
-	if err != nil {
-		log.Print("Cannot parse HTML")
-		os.Exit(1)
-	}
-	// Verify image path
-	doc.Find("img").EachWithBreak(func(i int, s *goquery.Selection) bool {
-		src, _ := s.Attr("src")
-		actualImageSrc = src
-		flgImage = src == "/images/image4.jpg"
-		return false
-	})
-
-	// Verify DOM structure
-	jumbotronChildren := doc.Find(".row div.jumbotron").Children().Size()
-	flgPrdExp = jumbotronChildren == 5
+	failures = append(failures, checkProductDocument(doc, "GET "+path, 1500)...)
+	fail := failuresOf("GET "+path, &failures)
 
 	// Verify product description
-	var productDesc string
-	doc.Find(".row div.jumbotron p").Each(func(i int, s *goquery.Selection) {
-		if i == 1 {
-			str := s.Text()
-			productDesc = str
-			flgPrdExp = strings.Contains(str, "1499") && flgPrdExp
-		}
-	})
+	if p := doc.Find(".row div.jumbotron p").Eq(1); p.Length() > 0 && !strings.Contains(p.Text(), "1499") {
+		fail("product description", "contains '1499'", p.Text())
+	}
 
 	// Verify purchased text (should not exist)
-	containerChildren := doc.Find(".jumbotron div.container").Children().Size()
-	flgPrdExp = containerChildren == 1 && flgPrdExp
-
-	flg = flgImage && flgPrdExp
-	// Overall verification
-	if flg == false {
-		log.Print("Invalid Content or DOM at GET /products/:id")
-		log.Printf("  productId=1500, loggedIn=%v", loggedIn)
-		if flgImage == false {
-			log.Printf("  Product image is incorrect (expected='/images/image4.jpg', actual='%s')", actualImageSrc)
-		}
-		if flgPrdExp == false {
-			log.Printf("  Product description section is incorrect (jumbotron.children=%d, containerChildren=%d, desc contains '1499'=%v)", 
-				jumbotronChildren, containerChildren, strings.Contains(productDesc, "1499"))
-		}
-		os.Exit(1)
+	if n := doc.Find(".jumbotron div.container").Children().Size(); n != 1 {
+		fail("purchased text", "1 child in .jumbotron .container", strconv.Itoa(n)+" children")
 	}
+
+	return failures
 }
 
-func validateUsers(id int, loggedIn bool) {
-	var flg bool
-	var flg30, flgDOM, flgTotal, flgTime bool
-	resp, err := http.Get(host + "/users/" + strconv.Itoa(id))
-	if err != nil {
-		log.Print("Cannot GET /users/:id")
-		os.Exit(1)
+// checkProductDocument runs the checks of GET /products/:id that hold for any session and at any time
+func checkProductDocument(doc *goquery.Document, endpoint string, id int) []validationFailure {
+	var failures []validationFailure
+	fail := failuresOf(endpoint, &failures)
+
+	// Verify image path
+	expected := "/images/image" + strconv.Itoa((id+4)%5) + ".jpg"
+	if src, _ := doc.Find("img").First().Attr("src"); src != expected {
+		fail("product image", expected, src)
 	}
-	defer resp.Body.Close()
-	
-	if resp.StatusCode != http.StatusOK {
-		log.Printf("GET /users/:id returned status %d", resp.StatusCode)
-		os.Exit(1)
+
+	// Verify DOM structure
+	if n := doc.Find(".row div.jumbotron").Children().Size(); n != 5 {
+		fail("jumbotron DOM", "5 children", strconv.Itoa(n)+" children")
 	}
-	
-	doc, err := goquery.NewDocumentFromReader(resp.Body)
-	if err != nil {
-		log.Print("Cannot parse HTML")
-		os.Exit(1)
+
+	return failures
+}
+
+func validateUsers(id int, loggedIn bool) []validationFailure {
+	path := "/users/" + strconv.Itoa(id)
+	doc, failures := fetchDocument(context.Background(), path)
+	if doc == nil {
+		return failures
 	}
+	failures = append(failures, checkUserDocument(doc, "GET "+path)...)
+	fail := failuresOf("GET "+path, &failures)
 
 	// Verify that there are 30 history items
-	rowChildren := doc.Find(".row").Children().Size()
-	flg30 = rowChildren == 30
+	if n := doc.Find(".row").Children().Size(); n != 30 {
+		fail("number of purchase history items", "30", strconv.Itoa(n))
+	}
 
-	// Verify DOM structure
-	panelChildren := doc.Find(".panel-default").First().Children().Size()
-	panelBodyChildren := doc.Find(".panel-body").First().Children().Size()
-	flgDOM = panelChildren == 2
-	flgDOM = panelBodyChildren == 7 && flgDOM
+	// The page is fetched without a session, so there is no comment form
+	if n := doc.Find(".panel-default").First().Children().Size(); n != 2 {
+		fail("user page DOM", ".panel-default=2", ".panel-default="+strconv.Itoa(n))
+	}
 
 	// Verify total amount
-	sum := getTotalPay(id)
-	var actualTotal string
-	doc.Find(".container h4").EachWithBreak(func(_ int, s *goquery.Selection) bool {
-		str := s.Text()
-		actualTotal = str
-		flgTotal = str == "合計金額: "+sum+"円"
-		return false
-	})
+	expectedTotal := "合計金額: " + strconv.Itoa(ledger.expectedTotal(id)) + "円"
+	if actual := doc.Find(".container h4").First().Text(); actual != expectedTotal {
+		fail("total purchase amount", expectedTotal, actual)
+	}
 
-	flgTime = true
-	var firstProductHref string
-	var purchaseTime string
 	if loggedIn {
 		// Verify that the last purchased product appears first
-		doc.Find(".panel-heading a").EachWithBreak(func(_ int, s *goquery.Selection) bool {
-			str, _ := s.Attr("href")
-			firstProductHref = str
-			flgTime = strings.Contains(str, "10000") && flgTime
-			return false
-		})
-		
+		if a := doc.Find(".panel-heading a").First(); a.Length() > 0 {
+			if href, _ := a.Attr("href"); !strings.Contains(href, "10000") {
+				fail("latest purchase", "/products/10000", href)
+			}
+		}
+
 		// Verify that the purchase time is recent
 		// Note: To avoid timezone issues, we don't strictly check the time
-		// Instead, we only verify that the latest purchased product has the correct ID
-		doc.Find(".panel-body p").Each(func(i int, s *goquery.Selection) {
-			if i == 2 {
-				str := s.Text()
-				purchaseTime = str
-				timeformat := "2006-01-02 15:04:05 -0700"
-				_, err := time.Parse(timeformat, str+" +0900")
-				if err != nil {
-					// Only fail if the time format is incorrect
-					flgTime = false
-					return
-				}
-				// OK if time format is correct (allowing timezone differences between environments)
+		// Instead, we only verify that the time format is correct
+		if p := doc.Find(".panel-body p").Eq(2); p.Length() > 0 {
+			timeformat := "2006-01-02 15:04:05 -0700"
+			if _, err := time.Parse(timeformat, p.Text()+" +0900"); err != nil {
+				fail("purchase time format", "2006-01-02 15:04:05", p.Text())
 			}
-		})
-	}
-
-	// Overall verification
-	flg = flg30 && flgDOM && flgTotal && flgTime
-	if flg == false {
-		log.Print("Invalid Content or DOM at GET /users/:id")
-		log.Printf("  userId=%d, loggedIn=%v", id, loggedIn)
-		if flg30 == false {
-			log.Printf("  Number of purchase history items is incorrect (got=%d, expected=30)", rowChildren)
 		}
-		if flgDOM == false {
-			log.Printf("  User page DOM is incorrect (.panel-default children=%d, .panel-body children=%d)", panelChildren, panelBodyChildren)
-		}
-		if flgTotal == false {
-			log.Printf("  Total purchase amount is incorrect (expected='合計金額: %s円', actual='%s')", sum, actualTotal)
-		}
-		if flgTime == false {
-			log.Printf("  Recent purchase history is incorrect (firstProduct='%s', purchaseTime='%s')", firstProductHref, purchaseTime)
-		}
-		os.Exit(1)
 	}
+
+	return failures
 }
 
-func getTotalPay(userID int) string {
-	db, err := getDB()
-	if err != nil {
-		panic(err.Error())
+var totalPayText = regexp.MustCompile(`^合計金額: [0-9]+円$`)
+
+// checkUserDocument runs the checks of GET /users/:id that hold for any session and at any time
+func checkUserDocument(doc *goquery.Document, endpoint string) []validationFailure {
+	var failures []validationFailure
+	fail := failuresOf(endpoint, &failures)
+
+	rows := doc.Find(".row").Children().Size()
+	if rows > 30 {
+		fail("number of purchase history items", "<= 30", strconv.Itoa(rows))
 	}
-	defer db.Close()
 
-	query := `
-    SELECT SUM(p.price) as total_pay
-    FROM histories as h
-    INNER JOIN products as p
-    ON p.id = h.product_id
-    WHERE h.user_id = ?`
-	var totalPay string
-	err = db.QueryRow(query, userID).Scan(&totalPay)
-	if err != nil {
-		panic(err.Error())
+	// Verify DOM structure
+	if rows > 0 {
+		if n := doc.Find(".panel-body").First().Children().Size(); n != 7 {
+			fail("user page DOM", ".panel-body=7", ".panel-body="+strconv.Itoa(n))
+		}
+	}
+
+	// Verify total amount format
+	if total := doc.Find(".container h4").First().Text(); !totalPayText.MatchString(total) {
+		fail("total purchase amount", "合計金額: N円", total)
 	}
 
-	return totalPay
+	return failures
 }